@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessLogEntry is one request from a CloudFront standard log
+type AccessLogEntry struct {
+	Time     time.Time
+	ClientIp string
+	Method   string
+	Path     string
+	Status   int
+	Bytes    int64
+	Referrer string
+}
+
+// defaultAccessLogFields is the column order of CloudFront standard logs, used when a file
+// has no #Fields header
+var defaultAccessLogFields = []string{
+	"date", "time", "x-edge-location", "sc-bytes", "c-ip", "cs-method", "cs(Host)", "cs-uri-stem",
+	"sc-status", "cs(Referer)", "cs(User-Agent)", "cs-uri-query", "cs(Cookie)", "x-edge-result-type",
+	"x-edge-request-id", "x-host-header", "cs-protocol", "cs-bytes", "time-taken",
+}
+
+// ParseAccessLog reads a CloudFront standard log file, which is tab separated and gzipped when
+// delivered. Plain text input is accepted too.
+func ParseAccessLog(reader io.Reader) ([]AccessLogEntry, error) {
+	bufferedReader := bufio.NewReader(reader)
+	if magic, err := bufferedReader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(bufferedReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress access log: %w", err)
+		}
+		defer gzipReader.Close()
+		bufferedReader = bufio.NewReader(gzipReader)
+	}
+
+	columns := accessLogColumns(defaultAccessLogFields)
+	var entries []AccessLogEntry
+	scanner := bufio.NewScanner(bufferedReader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if fields, ok := strings.CutPrefix(line, "#Fields:"); ok {
+			columns = accessLogColumns(strings.Fields(fields))
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := parseAccessLogLine(strings.Split(line, "\t"), columns)
+		if err != nil {
+			return nil, fmt.Errorf("access log line %d: %w", lineNumber, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read access log: %w", err)
+	}
+	return entries, nil
+}
+
+func accessLogColumns(fields []string) map[string]int {
+	columns := map[string]int{}
+	for index, field := range fields {
+		columns[field] = index
+	}
+	return columns
+}
+
+func parseAccessLogLine(values []string, columns map[string]int) (AccessLogEntry, error) {
+	value := func(field string) string {
+		index, ok := columns[field]
+		if !ok || index >= len(values) || values[index] == "-" {
+			return ""
+		}
+		return values[index]
+	}
+	var entry AccessLogEntry
+	var err error
+	entry.Time, err = time.Parse("2006-01-02 15:04:05", value("date")+" "+value("time"))
+	if err != nil {
+		return entry, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	if status := value("sc-status"); status != "" && status != "000" {
+		entry.Status, err = strconv.Atoi(status)
+		if err != nil {
+			return entry, fmt.Errorf("invalid status '%s'", status)
+		}
+	}
+	if bytes := value("sc-bytes"); bytes != "" {
+		entry.Bytes, err = strconv.ParseInt(bytes, 10, 64)
+		if err != nil {
+			return entry, fmt.Errorf("invalid byte count '%s'", bytes)
+		}
+	}
+	entry.ClientIp = value("c-ip")
+	entry.Method = value("cs-method")
+	// CloudFront percent-encodes these fields; keep the raw value if it does not decode
+	entry.Path = value("cs-uri-stem")
+	if path, err := url.PathUnescape(entry.Path); err == nil {
+		entry.Path = path
+	}
+	entry.Referrer = value("cs(Referer)")
+	if referrer, err := url.PathUnescape(entry.Referrer); err == nil {
+		entry.Referrer = referrer
+	}
+	return entry, nil
+}
+
+// AccessLogSummary totals the requests of a site between From and Until
+type AccessLogSummary struct {
+	DomainName  string
+	From        time.Time
+	Until       time.Time
+	Requests    int
+	Bytes       int64
+	Clients     map[string]int
+	Paths       map[string]int
+	StatusCodes map[int]int
+	// Referrers counts other sites linking in; links within the site are left out
+	Referrers map[string]int
+}
+
+func NewAccessLogSummary(domainName string, from time.Time, until time.Time) *AccessLogSummary {
+	return &AccessLogSummary{
+		DomainName:  domainName,
+		From:        from,
+		Until:       until,
+		Clients:     map[string]int{},
+		Paths:       map[string]int{},
+		StatusCodes: map[int]int{},
+		Referrers:   map[string]int{},
+	}
+}
+
+// Add counts entry if it falls inside the summary's time range
+func (summary *AccessLogSummary) Add(entry AccessLogEntry) {
+	if entry.Time.Before(summary.From) || !entry.Time.Before(summary.Until) {
+		return
+	}
+	summary.Requests++
+	summary.Bytes += entry.Bytes
+	summary.Clients[entry.ClientIp]++
+	summary.Paths[entry.Path]++
+	summary.StatusCodes[entry.Status]++
+	if entry.Referrer != "" {
+		if referrerUrl, err := url.Parse(entry.Referrer); err != nil || !strings.EqualFold(referrerUrl.Hostname(), summary.DomainName) {
+			summary.Referrers[entry.Referrer]++
+		}
+	}
+}
+
+// AccessLogCount is a value and how many requests had it
+type AccessLogCount struct {
+	Value string
+	Count int
+}
+
+// TopAccessLogCounts returns the limit most frequent values, most frequent first
+func TopAccessLogCounts(counts map[string]int, limit int) []AccessLogCount {
+	top := make([]AccessLogCount, 0, len(counts))
+	for value, count := range counts {
+		top = append(top, AccessLogCount{Value: value, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Value < top[j].Value
+	})
+	if len(top) > limit {
+		top = top[:max(limit, 0)]
+	}
+	return top
+}
+
+// WriteReport prints the summary with the top limit paths and referrers
+func (summary *AccessLogSummary) WriteReport(writer io.Writer, limit int) {
+	fmt.Fprintf(writer, "Traffic for %s from %s to %s\n", summary.DomainName, summary.From.Local().Format(time.DateTime), summary.Until.Local().Format(time.DateTime))
+	fmt.Fprintf(writer, "Requests:       %d\n", summary.Requests)
+	fmt.Fprintf(writer, "Bytes served:   %s\n", formatByteCount(summary.Bytes))
+	fmt.Fprintf(writer, "Unique clients: %d\n", len(summary.Clients))
+	if summary.Requests == 0 {
+		return
+	}
+
+	fmt.Fprintln(writer, "\nStatus codes:")
+	statusCodes := make([]int, 0, len(summary.StatusCodes))
+	for statusCode := range summary.StatusCodes {
+		statusCodes = append(statusCodes, statusCode)
+	}
+	sort.Ints(statusCodes)
+	for _, statusCode := range statusCodes {
+		// 000 means the viewer disconnected before CloudFront answered
+		fmt.Fprintf(writer, "  %03d  %8d\n", statusCode, summary.StatusCodes[statusCode])
+	}
+
+	fmt.Fprintln(writer, "\nTop paths:")
+	for _, path := range TopAccessLogCounts(summary.Paths, limit) {
+		fmt.Fprintf(writer, "  %8d  %s\n", path.Count, path.Value)
+	}
+	if len(summary.Referrers) > 0 {
+		fmt.Fprintln(writer, "\nTop referrers:")
+		for _, referrer := range TopAccessLogCounts(summary.Referrers, limit) {
+			fmt.Fprintf(writer, "  %8d  %s\n", referrer.Count, referrer.Value)
+		}
+	}
+}
+
+func formatByteCount(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value, exponent := float64(bytes)/unit, 0
+	for value >= unit && exponent < 4 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exponent])
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleAccessLog = "testdata/E2EXAMPLE.2026-10-18-12.a1b2c3d4.gz"
+
+func readSampleAccessLog(t *testing.T) []AccessLogEntry {
+	t.Helper()
+	file, err := os.Open(sampleAccessLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	entries, err := ParseAccessLog(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestParseAccessLog(t *testing.T) {
+	entries := readSampleAccessLog(t)
+	if len(entries) != 7 {
+		t.Fatalf("parsed %d entries; want 7", len(entries))
+	}
+	tests := []struct {
+		index int
+		want  AccessLogEntry
+	}{
+		{1, AccessLogEntry{
+			Time:     time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+			ClientIp: "192.0.2.1",
+			Method:   "GET",
+			Path:     "/index.html",
+			Status:   200,
+			Bytes:    1024,
+			Referrer: "https://news.example.org/item?id=42",
+		}},
+		// Percent-encoded paths are decoded
+		{2, AccessLogEntry{
+			Time:     time.Date(2026, 10, 18, 12, 10, 0, 0, time.UTC),
+			ClientIp: "192.0.2.2",
+			Method:   "GET",
+			Path:     "/docs/café.html",
+			Status:   200,
+			Bytes:    2048,
+			Referrer: "https://WWW.example.com/index.html",
+		}},
+		// Status 000 is a viewer that went away, and "-" is an empty value
+		{4, AccessLogEntry{
+			Time:     time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC),
+			ClientIp: "192.0.2.3",
+			Method:   "GET",
+			Path:     "/index.html",
+		}},
+	}
+	for _, test := range tests {
+		if got := entries[test.index]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("entry %d = %+v; want %+v", test.index, got, test.want)
+		}
+	}
+}
+
+func TestParseAccessLogWithoutFieldsHeader(t *testing.T) {
+	// Uncompressed input in the default column order
+	line := strings.Join([]string{"2026-10-18", "08:15:00", "IAD89-C1", "300", "198.51.100.9", "GET", "d111111abcdef8.cloudfront.net", "/a%20b.html", "304", "-"}, "\t")
+	entries, err := ParseAccessLog(strings.NewReader("#Version: 1.0\n" + line + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != "/a b.html" || entries[0].Status != 304 || entries[0].Bytes != 300 {
+		t.Errorf("entries = %+v", entries)
+	}
+
+	if _, err := ParseAccessLog(strings.NewReader("2026-10-18\tnoon\n")); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("error = %v; want the bad line reported", err)
+	}
+}
+
+func TestAccessLogSummaryAdd(t *testing.T) {
+	from := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	summary := NewAccessLogSummary("www.example.com", from, from.Add(time.Hour))
+	for _, entry := range readSampleAccessLog(t) {
+		summary.Add(entry)
+	}
+
+	// The requests at 11:59:59 and 13:00:00 fall outside the hour
+	if summary.Requests != 5 {
+		t.Errorf("requests = %d; want 5", summary.Requests)
+	}
+	if summary.Bytes != 4608 {
+		t.Errorf("bytes = %d; want 4608", summary.Bytes)
+	}
+	if len(summary.Clients) != 4 {
+		t.Errorf("clients = %v; want 4", summary.Clients)
+	}
+	wantStatusCodes := map[int]int{0: 1, 200: 3, 404: 1}
+	if !reflect.DeepEqual(summary.StatusCodes, wantStatusCodes) {
+		t.Errorf("status codes = %v; want %v", summary.StatusCodes, wantStatusCodes)
+	}
+	wantPaths := map[string]int{"/index.html": 3, "/docs/café.html": 1, "/missing": 1}
+	if !reflect.DeepEqual(summary.Paths, wantPaths) {
+		t.Errorf("paths = %v; want %v", summary.Paths, wantPaths)
+	}
+	// Links from the site itself are left out, whatever the case of the host
+	wantReferrers := map[string]int{"https://news.example.org/item?id=42": 2, "https://search.example.net/": 1}
+	if !reflect.DeepEqual(summary.Referrers, wantReferrers) {
+		t.Errorf("referrers = %v; want %v", summary.Referrers, wantReferrers)
+	}
+}
+
+func TestTopAccessLogCounts(t *testing.T) {
+	counts := map[string]int{"/b": 2, "/a": 2, "/c": 5, "/d": 1}
+	tests := []struct {
+		limit int
+		want  []AccessLogCount
+	}{
+		{10, []AccessLogCount{{"/c", 5}, {"/a", 2}, {"/b", 2}, {"/d", 1}}},
+		{2, []AccessLogCount{{"/c", 5}, {"/a", 2}}},
+		{0, []AccessLogCount{}},
+		{-1, []AccessLogCount{}},
+	}
+	for _, test := range tests {
+		if got := TopAccessLogCounts(counts, test.limit); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("TopAccessLogCounts(limit %d) = %v; want %v", test.limit, got, test.want)
+		}
+	}
+}
+
+func TestAccessLogHourPattern(t *testing.T) {
+	match := accessLogHourPattern.FindStringSubmatch("site/" + strings.TrimPrefix(sampleAccessLog, "testdata/"))
+	if match == nil || match[1] != "2026-10-18-12" {
+		t.Errorf("match = %v; want the hour of the log file", match)
+	}
+}
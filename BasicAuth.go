@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// BasicAuthCredential is a user allowed into a password-protected site
+type BasicAuthCredential struct {
+	User     string
+	Password string
+}
+
+// ParseBasicAuthCredential reads "user:password". The password may contain colons.
+func ParseBasicAuthCredential(value string) (BasicAuthCredential, error) {
+	user, password, found := strings.Cut(value, ":")
+	if !found || user == "" || password == "" {
+		return BasicAuthCredential{}, errors.New("expected user:password")
+	}
+	switch {
+	case strings.HasPrefix(password, "$2") || strings.HasPrefix(password, "$apr1$") || strings.HasPrefix(password, "{SHA}"):
+		return BasicAuthCredential{}, fmt.Errorf("password of '%s' looks like an htpasswd hash; hostit needs the plain password to derive its own hash", user)
+	}
+	return BasicAuthCredential{User: user, Password: password}, nil
+}
+
+// basicAuthFlag collects repeated "user:password" flags
+type basicAuthFlag struct {
+	credentials *[]BasicAuthCredential
+}
+
+func (flagValue basicAuthFlag) String() string {
+	if flagValue.credentials == nil {
+		return ""
+	}
+	var users []string
+	for _, credential := range *flagValue.credentials {
+		users = append(users, credential.User+":***")
+	}
+	return strings.Join(users, ",")
+}
+
+func (flagValue basicAuthFlag) Set(value string) error {
+	credential, err := ParseBasicAuthCredential(value)
+	if err != nil {
+		return err
+	}
+	*flagValue.credentials = append(*flagValue.credentials, credential)
+	return nil
+}
+
+// LoadBasicAuthFile reads one "user:password" per line, skipping blank lines and # comments
+func LoadBasicAuthFile(fileName string) ([]BasicAuthCredential, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open credentials file: %w", err)
+	}
+	defer file.Close()
+
+	var credentials []BasicAuthCredential
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		credential, err := ParseBasicAuthCredential(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", fileName, lineNumber, err)
+		}
+		credentials = append(credentials, credential)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	if len(credentials) == 0 {
+		return nil, fmt.Errorf("%s has no credentials", fileName)
+	}
+	return credentials, nil
+}
+
+// basicAuthStepTemplate answers 401 unless the Authorization header carries one of the
+// credentials. Only salted SHA-256 digests of the header tokens are in the function code, which
+// anyone allowed to describe CloudFront functions can read.
+const basicAuthStepTemplate = `var credentials = %s;
+var authorization = request.headers.authorization;
+var token = authorization ? authorization.value.replace(/^Basic\s+/i, '') : '';
+var digest = require('crypto').createHash('sha256').update(%s + token).digest('hex');
+if (credentials.indexOf(digest) === -1) {
+    return { statusCode: 401, statusDescription: 'Unauthorized', headers: { 'www-authenticate': { value: %s } } };
+}`
+
+// basicAuthStep compiles credentials into a step for the site domainName, which salts the
+// digests and names the realm
+func basicAuthStep(domainName string, credentials []BasicAuthCredential) (string, error) {
+	salt := "hostit:" + domainName + ":"
+	digests := make([]string, 0, len(credentials))
+	for _, credential := range credentials {
+		token := base64.StdEncoding.EncodeToString([]byte(credential.User + ":" + credential.Password))
+		digest := sha256.Sum256([]byte(salt + token))
+		digests = append(digests, hex.EncodeToString(digest[:]))
+	}
+	encodedDigests, err := json.Marshal(digests)
+	if err != nil {
+		return "", err
+	}
+	encodedSalt, err := json.Marshal(salt)
+	if err != nil {
+		return "", err
+	}
+	encodedChallenge, err := json.Marshal(fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, domainName))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(basicAuthStepTemplate, encodedDigests, encodedSalt, encodedChallenge), nil
+}
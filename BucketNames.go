@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const maxBucketNameLength = 63
+
+var invalidBucketNameCharacters = regexp.MustCompile(`[^a-z0-9-]+`)
+
+var ipAddressPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+\.[0-9]+$`)
+
+// DefaultBucketName builds "<account>-<domain>-hostit" with the domain's dots turned into
+// dashes, since dotted names break virtual-hosted TLS. Domains too long for S3's limit are
+// truncated and keep a short hash of the full domain, so the name stays unique and stable.
+func DefaultBucketName(accountNumber string, domainName string) string {
+	const suffix = "-hostit"
+	site := strings.Trim(invalidBucketNameCharacters.ReplaceAllString(strings.ToLower(domainName), "-"), "-")
+	name := accountNumber + "-" + site + suffix
+	if len(name) <= maxBucketNameLength {
+		return name
+	}
+	digest := sha256.Sum256([]byte(strings.ToLower(domainName)))
+	hash := "-" + hex.EncodeToString(digest[:])[:8]
+	available := maxBucketNameLength - len(accountNumber) - 1 - len(hash) - len(suffix)
+	return accountNumber + "-" + strings.TrimRight(site[:available], "-") + hash + suffix
+}
+
+// LegacyBucketName is the name earlier versions gave a site's bucket, "<account>-<domain>-hostit"
+// with the domain's dots kept. Those versions recorded no deployment state.
+func LegacyBucketName(accountNumber string, domainName string) string {
+	return fmt.Sprintf("%s-%s-hostit", accountNumber, domainName)
+}
+
+// ValidateBucketName applies S3's general purpose bucket naming rules, which existing buckets
+// follow. Dots are allowed here; see ValidateNewBucketName.
+func ValidateBucketName(name string) error {
+	switch {
+	case len(name) < 3 || len(name) > maxBucketNameLength:
+		return fmt.Errorf("bucket name '%s' must be 3 to %d characters long", name, maxBucketNameLength)
+	case invalidBucketNameCharacters.MatchString(strings.ReplaceAll(name, ".", "")):
+		return fmt.Errorf("bucket name '%s' may only contain lowercase letters, digits, dashes and dots", name)
+	case strings.Trim(name, "-.") != name:
+		return fmt.Errorf("bucket name '%s' must start and end with a letter or digit", name)
+	case strings.Contains(name, "..") || strings.Contains(name, ".-") || strings.Contains(name, "-."):
+		return fmt.Errorf("bucket name '%s' must not have a dot next to another dot or a dash", name)
+	case ipAddressPattern.MatchString(name):
+		return fmt.Errorf("bucket name '%s' must not look like an IP address", name)
+	case strings.HasPrefix(name, "xn--") || strings.HasPrefix(name, "sthree-"):
+		return fmt.Errorf("bucket name '%s' uses a prefix reserved by S3", name)
+	case strings.HasSuffix(name, "-s3alias") || strings.HasSuffix(name, "--ol-s3") || strings.HasSuffix(name, "--x-s3"):
+		return fmt.Errorf("bucket name '%s' uses a suffix reserved by S3", name)
+	}
+	return nil
+}
+
+// ValidateNewBucketName checks the name of a bucket hostit is about to create, which must also
+// be free of dots so the bucket can be reached over virtual-hosted TLS
+func ValidateNewBucketName(name string) error {
+	if err := ValidateBucketName(name); err != nil {
+		return err
+	}
+	if strings.Contains(name, ".") {
+		return fmt.Errorf("bucket name '%s' must not contain dots", name)
+	}
+	return nil
+}
+
+// resolveBucketName picks the bucket recorded for a previous deploy, then the requested name,
+// then the default name
+func resolveBucketName(deploymentState *DeploymentState, requestedName string, accountNumber string, domainName string) (string, error) {
+	if deploymentState != nil && deploymentState.BucketName != "" {
+		if requestedName != "" && requestedName != deploymentState.BucketName {
+			return "", fmt.Errorf("site is deployed to bucket %s; it cannot be moved to %s", deploymentState.BucketName, requestedName)
+		}
+		// Buckets from earlier versions may predate these rules, but they already exist
+		return deploymentState.BucketName, nil
+	}
+	name := requestedName
+	if name == "" {
+		if accountNumber == "" {
+			return "", errors.New("aws account number not set")
+		}
+		name = DefaultBucketName(accountNumber, domainName)
+	}
+	if err := ValidateBucketName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
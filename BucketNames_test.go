@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultBucketName(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"www.example.com", "123456789012-www-example-com-hostit"},
+		{"WWW.Example.COM", "123456789012-www-example-com-hostit"},
+		{"my_site.example.com.", "123456789012-my-site-example-com-hostit"},
+	}
+	for _, test := range tests {
+		if got := DefaultBucketName("123456789012", test.domain); got != test.want {
+			t.Errorf("DefaultBucketName(%s) = %s; want %s", test.domain, got, test.want)
+		}
+	}
+}
+
+func TestDefaultBucketNameForLongDomains(t *testing.T) {
+	// Both domains share the first 50 characters, so only the hash tells their buckets apart
+	first := strings.Repeat("a", 50) + ".first.example.com"
+	second := strings.Repeat("a", 50) + ".second.example.com"
+	name := DefaultBucketName("123456789012", first)
+	if len(name) > maxBucketNameLength {
+		t.Errorf("DefaultBucketName(%s) = %s, %d characters long", first, name, len(name))
+	}
+	if !strings.HasPrefix(name, "123456789012-aaaa") || !strings.HasSuffix(name, "-hostit") {
+		t.Errorf("DefaultBucketName(%s) = %s", first, name)
+	}
+	if err := ValidateNewBucketName(name); err != nil {
+		t.Error(err)
+	}
+	if DefaultBucketName("123456789012", first) != name {
+		t.Error("the same domain got a different bucket name")
+	}
+	if DefaultBucketName("123456789012", second) == name {
+		t.Error("different domains got the same bucket name")
+	}
+	// The truncated site name must not leave a dash before the hash
+	dashed := strings.Repeat("a", 33) + "." + strings.Repeat("b", 30) + ".com"
+	if name := DefaultBucketName("123456789012", dashed); strings.Contains(name, "--") {
+		t.Errorf("DefaultBucketName(%s) = %s", dashed, name)
+	}
+}
+
+func TestLegacyBucketName(t *testing.T) {
+	if got := LegacyBucketName("123456789012", "www.example.com"); got != "123456789012-www.example.com-hostit" {
+		t.Errorf("LegacyBucketName() = %s", got)
+	}
+}
+
+func TestValidateBucketName(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantErr    string
+		wantNewErr string
+	}{
+		{"123456789012-www-example-com-hostit", "", ""},
+		// Buckets from earlier versions have dots; hostit only refuses to create them
+		{"123456789012-www.example.com-hostit", "", "must not contain dots"},
+		{"ab", "3 to 63 characters", ""},
+		{strings.Repeat("a", 64), "3 to 63 characters", ""},
+		{"My-Bucket", "lowercase letters", ""},
+		{"my_bucket", "lowercase letters", ""},
+		{"-bucket", "start and end", ""},
+		{"bucket.", "start and end", ""},
+		{"my..bucket", "dot next to", ""},
+		{"my.-bucket", "dot next to", ""},
+		{"192.168.1.1", "IP address", ""},
+		{"xn--bucket", "prefix reserved", ""},
+		{"sthree-bucket", "prefix reserved", ""},
+		{"bucket-s3alias", "suffix reserved", ""},
+		{"bucket--ol-s3", "suffix reserved", ""},
+		{"bucket--x-s3", "suffix reserved", ""},
+	}
+	for _, test := range tests {
+		err := ValidateBucketName(test.name)
+		if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+			t.Errorf("ValidateBucketName(%s) = %v; want %q", test.name, err, test.wantErr)
+		}
+		wantNewErr := test.wantNewErr
+		if test.wantErr != "" {
+			wantNewErr = test.wantErr
+		}
+		err = ValidateNewBucketName(test.name)
+		if wantNewErr == "" && err != nil || wantNewErr != "" && (err == nil || !strings.Contains(err.Error(), wantNewErr)) {
+			t.Errorf("ValidateNewBucketName(%s) = %v; want %q", test.name, err, wantNewErr)
+		}
+	}
+}
+
+func TestResolveBucketName(t *testing.T) {
+	deployed := &DeploymentState{BucketName: "old.example.com-bucket"}
+	tests := []struct {
+		name      string
+		state     *DeploymentState
+		requested string
+		account   string
+		want      string
+		wantErr   string
+	}{
+		{"default name", nil, "", "123456789012", "123456789012-www-example-com-hostit", ""},
+		{"requested name", nil, "my-bucket", "", "my-bucket", ""},
+		{"invalid requested name", nil, "My-Bucket", "123456789012", "", "lowercase letters"},
+		{"no account", nil, "", "", "", "aws account number not set"},
+		{"empty state", &DeploymentState{}, "", "123456789012", "123456789012-www-example-com-hostit", ""},
+		// The recorded bucket wins even when it no longer passes the rules for new buckets
+		{"deployed bucket", deployed, "", "123456789012", "old.example.com-bucket", ""},
+		{"deployed bucket requested", deployed, "old.example.com-bucket", "", "old.example.com-bucket", ""},
+		{"move to another bucket", deployed, "my-bucket", "123456789012", "", "cannot be moved to my-bucket"},
+	}
+	for _, test := range tests {
+		got, err := resolveBucketName(test.state, test.requested, test.account, "www.example.com")
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error = %v; want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%s: resolveBucketName() = %s, %v; want %s", test.name, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// CacheControlRule assigns a Cache-Control value to every path matching Pattern. Patterns
+// use "/" separated globs where "**" spans any number of directories; a pattern without a
+// "/" is matched against the file name at any depth, so "*.html" covers every page.
+type CacheControlRule struct {
+	Pattern string
+	Value   string
+}
+
+// CacheControlRules are evaluated in order and the first matching rule wins
+type CacheControlRules []CacheControlRule
+
+func (rules *CacheControlRules) String() string {
+	var pairs []string
+	for _, rule := range *rules {
+		pairs = append(pairs, rule.Pattern+"="+rule.Value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (rules *CacheControlRules) Set(value string) error {
+	pattern, cacheControl, found := strings.Cut(value, "=")
+	pattern = strings.TrimPrefix(strings.TrimSpace(pattern), "/")
+	cacheControl = strings.TrimSpace(cacheControl)
+	if !found || pattern == "" || cacheControl == "" {
+		return fmt.Errorf("expected <pattern>=<cache-control>, got '%s'", value)
+	}
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+	}
+	*rules = append(*rules, CacheControlRule{Pattern: pattern, Value: cacheControl})
+	return nil
+}
+
+// Match returns the Cache-Control value for repoPath, if any rule applies
+func (rules CacheControlRules) Match(repoPath string) (string, bool) {
+	for _, rule := range rules {
+		if matchPathGlob(rule.Pattern, repoPath) {
+			return rule.Value, true
+		}
+	}
+	return "", false
+}
+
+// CloudFrontPathPattern converts the rule's glob to a CloudFront path pattern. CloudFront's
+// "*" already crosses directories, so the result can match slightly more than the glob.
+func (rule CacheControlRule) CloudFrontPathPattern() string {
+	pattern := rule.Pattern
+	if !strings.Contains(pattern, "/") {
+		pattern = "*" + pattern
+	}
+	pattern = strings.ReplaceAll(pattern, "**/", "*")
+	return strings.ReplaceAll(pattern, "**", "*")
+}
+
+// Ttls derives CloudFront min/default/max TTLs in seconds from the rule's Cache-Control value
+func (rule CacheControlRule) Ttls() (int64, int64, int64) {
+	var maxAge, sharedMaxAge int64 = -1, -1
+	for _, directive := range strings.Split(strings.ToLower(rule.Value), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch name {
+		case "no-cache", "no-store", "private":
+			return 0, 0, 0
+		case "max-age":
+			if seconds, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64); err == nil {
+				maxAge = seconds
+			}
+		case "s-maxage":
+			if seconds, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64); err == nil {
+				sharedMaxAge = seconds
+			}
+		}
+	}
+	if sharedMaxAge >= 0 {
+		return 0, sharedMaxAge, sharedMaxAge
+	}
+	if maxAge >= 0 {
+		return 0, maxAge, maxAge
+	}
+	// CloudFront's own defaults when the value sets no lifetime
+	return 0, 86400, 31536000
+}
+
+// matchPathGlob reports whether repoPath matches pattern (see CacheControlRule)
+func matchPathGlob(pattern string, repoPath string) bool {
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(repoPath))
+		return matched
+	}
+	return matchGlobSegments(strings.Split(pattern, "/"), strings.Split(repoPath, "/"))
+}
+
+func matchGlobSegments(patternSegments []string, pathSegments []string) bool {
+	if len(patternSegments) == 0 {
+		return len(pathSegments) == 0
+	}
+	if patternSegments[0] == "**" {
+		for skip := 0; skip <= len(pathSegments); skip++ {
+			if matchGlobSegments(patternSegments[1:], pathSegments[skip:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(pathSegments) == 0 {
+		return false
+	}
+	matched, _ := path.Match(patternSegments[0], pathSegments[0])
+	return matched && matchGlobSegments(patternSegments[1:], pathSegments[1:])
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cloudfrontTypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+// TTLs of the cache policy used when no Cache-Control rule matches. The zero minimum lets
+// Cache-Control: no-cache from the origin take effect.
+const (
+	defaultCachePolicyMinTtl     int64 = 0
+	defaultCachePolicyDefaultTtl int64 = 86400
+	defaultCachePolicyMaxTtl     int64 = 31536000
+)
+
+// cachingDisabledPolicyId is the AWS managed CachingDisabled policy. CloudFront rejects custom
+// policies that never cache but normalize Accept-Encoding, so zero TTLs use it instead.
+const cachingDisabledPolicyId = "4135ea2d-6df8-44a3-9df3-4b5a84be39ad"
+
+// cachePolicyConfig builds a cache policy keyed on the path only, which is all a static site
+// needs, with gzip and Brotli compressed variants cached separately.
+func cachePolicyConfig(minTtl int64, defaultTtl int64, maxTtl int64) *cloudfrontTypes.CachePolicyConfig {
+	return &cloudfrontTypes.CachePolicyConfig{
+		// Policies are shared between sites, so the name identifies the exact configuration
+		Name:       aws.String(fmt.Sprintf("hostit-cache-%d-%d-%d", minTtl, defaultTtl, maxTtl)),
+		Comment:    aws.String("Hostit static site caching"),
+		MinTTL:     aws.Int64(minTtl),
+		DefaultTTL: aws.Int64(defaultTtl),
+		MaxTTL:     aws.Int64(maxTtl),
+		ParametersInCacheKeyAndForwardedToOrigin: &cloudfrontTypes.ParametersInCacheKeyAndForwardedToOrigin{
+			EnableAcceptEncodingGzip:   aws.Bool(true),
+			EnableAcceptEncodingBrotli: aws.Bool(true),
+			HeadersConfig: &cloudfrontTypes.CachePolicyHeadersConfig{
+				HeaderBehavior: cloudfrontTypes.CachePolicyHeaderBehaviorNone,
+			},
+			CookiesConfig: &cloudfrontTypes.CachePolicyCookiesConfig{
+				CookieBehavior: cloudfrontTypes.CachePolicyCookieBehaviorNone,
+			},
+			QueryStringsConfig: &cloudfrontTypes.CachePolicyQueryStringsConfig{
+				QueryStringBehavior: cloudfrontTypes.CachePolicyQueryStringBehaviorNone,
+			},
+		},
+	}
+}
+
+// ensureCachePolicyForTtls returns the id of a cache policy with the given TTLs
+func ensureCachePolicyForTtls(ctx context.Context, cloudfrontClient *cloudfront.Client, minTtl int64, defaultTtl int64, maxTtl int64) (string, error) {
+	if maxTtl == 0 {
+		return cachingDisabledPolicyId, nil
+	}
+	return ensureCachePolicy(ctx, cloudfrontClient, cachePolicyConfig(minTtl, defaultTtl, maxTtl))
+}
+
+// ensureCachePolicy returns the id of the custom cache policy named in policyConfig, creating
+// it when missing and bringing an existing one up to date.
+func ensureCachePolicy(ctx context.Context, cloudfrontClient *cloudfront.Client, policyConfig *cloudfrontTypes.CachePolicyConfig) (string, error) {
+	name := aws.ToString(policyConfig.Name)
+	var marker *string
+	for {
+		listOut, err := cloudfrontClient.ListCachePolicies(ctx, &cloudfront.ListCachePoliciesInput{
+			Type:   cloudfrontTypes.CachePolicyTypeCustom,
+			Marker: marker,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed listing cache policies: %w", err)
+		}
+		if listOut.CachePolicyList == nil {
+			break
+		}
+		for _, summary := range listOut.CachePolicyList.Items {
+			if summary.CachePolicy == nil || summary.CachePolicy.CachePolicyConfig == nil {
+				continue
+			}
+			if aws.ToString(summary.CachePolicy.CachePolicyConfig.Name) != name {
+				continue
+			}
+			policyId := summary.CachePolicy.Id
+			getOut, err := cloudfrontClient.GetCachePolicyConfig(ctx, &cloudfront.GetCachePolicyConfigInput{Id: policyId})
+			if err != nil {
+				return "", fmt.Errorf("failed reading cache policy '%s': %w", name, err)
+			}
+			_, err = cloudfrontClient.UpdateCachePolicy(ctx, &cloudfront.UpdateCachePolicyInput{
+				Id:                policyId,
+				IfMatch:           getOut.ETag,
+				CachePolicyConfig: policyConfig,
+			})
+			if err != nil {
+				return "", fmt.Errorf("failed updating cache policy '%s': %w", name, err)
+			}
+			return aws.ToString(policyId), nil
+		}
+		if listOut.CachePolicyList.NextMarker == nil || *listOut.CachePolicyList.NextMarker == "" {
+			break
+		}
+		marker = listOut.CachePolicyList.NextMarker
+	}
+
+	createOut, err := cloudfrontClient.CreateCachePolicy(ctx, &cloudfront.CreateCachePolicyInput{
+		CachePolicyConfig: policyConfig,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed creating cache policy '%s': %w", name, err)
+	}
+	if createOut.CachePolicy == nil || createOut.CachePolicy.Id == nil {
+		return "", fmt.Errorf("unexpected empty cache policy response for '%s'", name)
+	}
+	return *createOut.CachePolicy.Id, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cloudfrontTypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+// distributionSettings is the part of a distribution config hostit manages. It is applied both
+// when a distribution is created and when an existing one is updated, so re-deploying with new
+// options brings the distribution in line with them.
+type distributionSettings struct {
+	originPath               string
+	defaultCachePolicyId     string
+	originRequestPolicyId    string
+	responseHeadersPolicyId  string
+	viewerRequestFunctionArn string
+	pathCacheBehaviors       []pathCacheBehavior
+	siteMode                 SiteMode
+	priceClass               cloudfrontTypes.PriceClass
+	httpVersion              cloudfrontTypes.HttpVersion
+	ipv6Enabled              bool
+	// logBucket is the S3 domain name CloudFront writes standard logs to, or empty for no logs
+	logBucket string
+	logPrefix string
+}
+
+// maxCacheBehaviors is CloudFront's default quota of cache behaviors per distribution
+const maxCacheBehaviors = 25
+
+// pathCacheBehavior caches paths matching pathPattern with their own cache policy, and
+// their own response headers policy when responseHeadersPolicyId is set
+type pathCacheBehavior struct {
+	pathPattern             string
+	cachePolicyId           string
+	responseHeadersPolicyId string
+}
+
+func ParsePriceClass(value string) (cloudfrontTypes.PriceClass, error) {
+	switch value {
+	case "All", "all":
+		return cloudfrontTypes.PriceClassPriceClassAll, nil
+	case "200":
+		return cloudfrontTypes.PriceClassPriceClass200, nil
+	case "100":
+		return cloudfrontTypes.PriceClassPriceClass100, nil
+	}
+	return "", fmt.Errorf("unknown price class '%s' (expected All, 200 or 100)", value)
+}
+
+func ParseHttpVersion(value string) (cloudfrontTypes.HttpVersion, error) {
+	for _, httpVersion := range cloudfrontTypes.HttpVersionHttp11.Values() {
+		if string(httpVersion) == value {
+			return httpVersion, nil
+		}
+	}
+	return "", fmt.Errorf("unknown http version '%s' (expected http1.1, http2, http3 or http2and3)", value)
+}
+
+// applyDistributionSettings writes settings into distributionConfig, routing every cache
+// behavior to originId and pointing that origin at originPath. Existing cache behaviors are
+// replaced; other origins, aliases and the viewer certificate are left alone.
+func applyDistributionSettings(distributionConfig *cloudfrontTypes.DistributionConfig, originId string, settings distributionSettings) {
+	if distributionConfig.Origins != nil {
+		for index := range distributionConfig.Origins.Items {
+			if aws.ToString(distributionConfig.Origins.Items[index].Id) == originId {
+				distributionConfig.Origins.Items[index].OriginPath = aws.String(settings.originPath)
+			}
+		}
+	}
+	var originRequestPolicyId *string
+	if settings.originRequestPolicyId != "" {
+		originRequestPolicyId = aws.String(settings.originRequestPolicyId)
+	}
+	var responseHeadersPolicyId *string
+	if settings.responseHeadersPolicyId != "" {
+		responseHeadersPolicyId = aws.String(settings.responseHeadersPolicyId)
+	}
+	cachedMethods := &cloudfrontTypes.CachedMethods{
+		Quantity: aws.Int32(2),
+		Items:    []cloudfrontTypes.Method{cloudfrontTypes.MethodGet, cloudfrontTypes.MethodHead},
+	}
+
+	distributionConfig.DefaultRootObject = aws.String("index.html")
+	distributionConfig.DefaultCacheBehavior = &cloudfrontTypes.DefaultCacheBehavior{
+		TargetOriginId:       aws.String(originId),
+		ViewerProtocolPolicy: cloudfrontTypes.ViewerProtocolPolicyRedirectToHttps,
+		Compress:             aws.Bool(true),
+		AllowedMethods: &cloudfrontTypes.AllowedMethods{
+			Quantity:      aws.Int32(3),
+			Items:         []cloudfrontTypes.Method{cloudfrontTypes.MethodGet, cloudfrontTypes.MethodHead, cloudfrontTypes.MethodOptions},
+			CachedMethods: cachedMethods,
+		},
+		CachePolicyId:           aws.String(settings.defaultCachePolicyId),
+		OriginRequestPolicyId:   originRequestPolicyId,
+		FunctionAssociations:    viewerRequestFunctionAssociations(settings.viewerRequestFunctionArn),
+		ResponseHeadersPolicyId: responseHeadersPolicyId,
+	}
+
+	cacheBehaviors := make([]cloudfrontTypes.CacheBehavior, 0, len(settings.pathCacheBehaviors))
+	for _, behavior := range settings.pathCacheBehaviors {
+		behaviorResponseHeadersPolicyId := responseHeadersPolicyId
+		if behavior.responseHeadersPolicyId != "" {
+			behaviorResponseHeadersPolicyId = aws.String(behavior.responseHeadersPolicyId)
+		}
+		cacheBehaviors = append(cacheBehaviors, cloudfrontTypes.CacheBehavior{
+			PathPattern:          aws.String(behavior.pathPattern),
+			TargetOriginId:       aws.String(originId),
+			ViewerProtocolPolicy: cloudfrontTypes.ViewerProtocolPolicyRedirectToHttps,
+			Compress:             aws.Bool(true),
+			AllowedMethods: &cloudfrontTypes.AllowedMethods{
+				Quantity:      aws.Int32(2),
+				Items:         []cloudfrontTypes.Method{cloudfrontTypes.MethodGet, cloudfrontTypes.MethodHead},
+				CachedMethods: cachedMethods,
+			},
+			CachePolicyId:           aws.String(behavior.cachePolicyId),
+			OriginRequestPolicyId:   originRequestPolicyId,
+			FunctionAssociations:    viewerRequestFunctionAssociations(settings.viewerRequestFunctionArn),
+			ResponseHeadersPolicyId: behaviorResponseHeadersPolicyId,
+		})
+	}
+	distributionConfig.CacheBehaviors = &cloudfrontTypes.CacheBehaviors{
+		Quantity: aws.Int32(int32(len(cacheBehaviors))),
+		Items:    cacheBehaviors,
+	}
+
+	distributionConfig.CustomErrorResponses = customErrorResponses(settings.siteMode)
+	distributionConfig.PriceClass = settings.priceClass
+	distributionConfig.HttpVersion = settings.httpVersion
+	distributionConfig.IsIPV6Enabled = aws.Bool(settings.ipv6Enabled)
+	distributionConfig.Logging = &cloudfrontTypes.LoggingConfig{
+		Enabled:        aws.Bool(settings.logBucket != ""),
+		Bucket:         aws.String(settings.logBucket),
+		Prefix:         aws.String(settings.logPrefix),
+		IncludeCookies: aws.Bool(false),
+	}
+}
+
+// customErrorResponses maps missing objects to the page chosen by the site mode. Without
+// s3:ListBucket, OAC reports a missing object as 403 rather than 404, so both are mapped.
+func customErrorResponses(siteMode SiteMode) *cloudfrontTypes.CustomErrorResponses {
+	var responsePagePath, responseCode string
+	switch siteMode {
+	case SiteModeSpa:
+		responsePagePath, responseCode = "/index.html", "200"
+	case SiteModeStatic:
+		responsePagePath, responseCode = "/404.html", "404"
+	default:
+		return &cloudfrontTypes.CustomErrorResponses{Quantity: aws.Int32(0)}
+	}
+	var items []cloudfrontTypes.CustomErrorResponse
+	for _, errorCode := range []int32{403, 404} {
+		items = append(items, cloudfrontTypes.CustomErrorResponse{
+			ErrorCode:          aws.Int32(errorCode),
+			ResponsePagePath:   aws.String(responsePagePath),
+			ResponseCode:       aws.String(responseCode),
+			ErrorCachingMinTTL: aws.Int64(10),
+		})
+	}
+	return &cloudfrontTypes.CustomErrorResponses{
+		Quantity: aws.Int32(int32(len(items))),
+		Items:    items,
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cloudfrontTypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+// withQueryStringFunction is defined ahead of the handler so steps answering with a redirect can
+// carry the query string of the request over to its location
+const withQueryStringFunction = `function withQueryString(location, querystring) {
+    var query = [];
+    for (var name in querystring) {
+        var parameter = querystring[name];
+        var values = parameter.multiValue ? parameter.multiValue : [parameter];
+        for (var i = 0; i < values.length; i++) {
+            query.push(values[i].value === '' ? name : name + '=' + values[i].value);
+        }
+    }
+    if (query.length === 0) {
+        return location;
+    }
+    return location + (location.indexOf('?') === -1 ? '?' : '&') + query.join('&');
+}`
+
+// prettyUrlsStepTemplate serves docs/index.html for /docs/ and redirects /docs to /docs/, so
+// relative links in the page resolve against the directory. Only directories with an index.html
+// are redirected, which leaves extensionless files such as /LICENSE alone. Single page apps get
+// the index served for /docs instead, since client-side routers do not expect the slash.
+const prettyUrlsStepTemplate = `var indexDirectories = %s;
+var uri = request.uri;
+if (uri.endsWith('/')) {
+    request.uri = uri + 'index.html';
+} else if (indexDirectories[uri]) {
+    if (%t) {
+        request.uri = uri + '/index.html';
+    } else {
+        return { statusCode: 301, statusDescription: 'Moved Permanently', headers: { location: { value: withQueryString(uri + '/', request.querystring) } } };
+    }
+}`
+
+// prettyUrlsStep compiles the directories holding an index.html among the uploaded files into a
+// step. With rewriteOnly the step serves those directories without redirecting.
+func prettyUrlsStep(filesToUpload []string, rewriteOnly bool) (string, error) {
+	indexDirectories := map[string]bool{}
+	for _, repoPath := range filesToUpload {
+		if directory, ok := strings.CutSuffix(repoPath, "/index.html"); ok {
+			indexDirectories["/"+directory] = true
+		}
+	}
+	encoded, err := json.Marshal(indexDirectories)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(prettyUrlsStepTemplate, encoded, rewriteOnly), nil
+}
+
+// stripHtmlStep serves about.html for /about and redirects /about.html to /about, keeping the
+// query string. Directory indexes then need a trailing slash, since /docs is looked up as
+// docs.html.
+const stripHtmlStep = `var uri = request.uri;
+if (uri.endsWith('/index.html')) {
+    return { statusCode: 301, statusDescription: 'Moved Permanently', headers: { location: { value: withQueryString(uri.slice(0, -'index.html'.length), request.querystring) } } };
+}
+if (uri.endsWith('.html')) {
+    return { statusCode: 301, statusDescription: 'Moved Permanently', headers: { location: { value: withQueryString(uri.slice(0, -'.html'.length), request.querystring) } } };
+}
+if (uri.endsWith('/')) {
+    request.uri = uri + 'index.html';
+} else if (uri.split('/').pop().indexOf('.') === -1) {
+    request.uri = uri + '.html';
+}`
+
+// redirectsStepTemplate answers requests matching a redirect rule. Paths are compared without
+// a trailing slash, and a splat rule also matches its own directory. As on Netlify, the query
+// string of the request is carried over to the location.
+const redirectsStepTemplate = `var redirects = %s;
+var uri = request.uri;
+var path = uri.length > 1 && uri.endsWith('/') ? uri.slice(0, -1) : uri;
+for (var i = 0; i < redirects.length; i++) {
+    var rule = redirects[i];
+    var location = null;
+    if (rule.prefix !== undefined) {
+        if (uri.startsWith(rule.prefix) || path === rule.prefix.slice(0, -1)) {
+            location = rule.to.split(':splat').join(uri.slice(rule.prefix.length));
+        }
+    } else if (path === rule.from) {
+        location = rule.to;
+    }
+    if (location !== null) {
+        return { statusCode: rule.status, statusDescription: rule.status === 301 ? 'Moved Permanently' : 'Found', headers: { location: { value: withQueryString(location, request.querystring) } } };
+    }
+}`
+
+// redirectsStep compiles rules into a step, checked in file order so the first match wins
+func redirectsStep(rules []RedirectRule) (string, error) {
+	type compiledRule struct {
+		From   string `json:"from,omitempty"`
+		Prefix string `json:"prefix,omitempty"`
+		To     string `json:"to"`
+		Status int    `json:"status"`
+	}
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		compiledRule := compiledRule{To: rule.To, Status: rule.Status}
+		if rule.IsSplat() {
+			compiledRule.Prefix = strings.TrimSuffix(rule.From, "*")
+		} else if len(rule.From) > 1 {
+			compiledRule.From = strings.TrimSuffix(rule.From, "/")
+		} else {
+			compiledRule.From = rule.From
+		}
+		compiled = append(compiled, compiledRule)
+	}
+	encoded, err := json.Marshal(compiled)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(redirectsStepTemplate, encoded), nil
+}
+
+// ViewerRequestFunction assembles the single viewer-request CloudFront Function hostit attaches
+// to a distribution. CloudFront allows one function per event type, so every feature adds a
+// step; a step can rewrite request or end the chain by returning a response.
+type ViewerRequestFunction struct {
+	steps []string
+}
+
+func (viewerRequestFunction *ViewerRequestFunction) AddStep(step string) {
+	viewerRequestFunction.steps = append(viewerRequestFunction.steps, step)
+}
+
+func (viewerRequestFunction ViewerRequestFunction) IsEmpty() bool {
+	return len(viewerRequestFunction.steps) == 0
+}
+
+// Code renders the cloudfront-js-2.0 source, wrapping each step in its own block scope after the
+// helper functions steps share
+func (viewerRequestFunction ViewerRequestFunction) Code() string {
+	var builder strings.Builder
+	builder.WriteString(withQueryStringFunction + "\n\n")
+	builder.WriteString("function handler(event) {\n    var request = event.request;\n")
+	for _, step := range viewerRequestFunction.steps {
+		builder.WriteString("    {\n")
+		for _, line := range strings.Split(step, "\n") {
+			builder.WriteString("        " + line + "\n")
+		}
+		builder.WriteString("    }\n")
+	}
+	builder.WriteString("    return request;\n}\n")
+	return builder.String()
+}
+
+var invalidCloudFrontNameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// cloudFrontResourceName builds a name within CloudFront's 64 character limit for functions
+// and policies, keeping it unique per site with a short hash of the full name.
+func cloudFrontResourceName(prefix string, siteName string) string {
+	const maxLength = 64
+	name := prefix + "-" + invalidCloudFrontNameCharacters.ReplaceAllString(siteName, "-")
+	if len(name) <= maxLength {
+		return name
+	}
+	digest := sha256.Sum256([]byte(siteName))
+	suffix := "-" + hex.EncodeToString(digest[:])[:8]
+	return strings.TrimRight(name[:maxLength-len(suffix)], "-") + suffix
+}
+
+// publishCloudFrontFunction creates the named function, or updates it if it already exists,
+// publishes it to LIVE and returns its ARN.
+func publishCloudFrontFunction(ctx context.Context, cloudfrontClient *cloudfront.Client, name string, comment string, code string) (string, error) {
+	const maxCodeBytes = 10 * 1024
+	if len(code) > maxCodeBytes {
+		return "", fmt.Errorf("CloudFront function '%s' is %d bytes, over the %d byte limit; reduce the number of redirect rules or directories", name, len(code), maxCodeBytes)
+	}
+	functionConfig := &cloudfrontTypes.FunctionConfig{
+		Comment: aws.String(comment),
+		Runtime: cloudfrontTypes.FunctionRuntimeCloudfrontJs20,
+	}
+
+	var etag *string
+	describeOut, err := cloudfrontClient.DescribeFunction(ctx, &cloudfront.DescribeFunctionInput{
+		Name:  aws.String(name),
+		Stage: cloudfrontTypes.FunctionStageDevelopment,
+	})
+	var noSuchFunction *cloudfrontTypes.NoSuchFunctionExists
+	switch {
+	case err == nil:
+		updateOut, err := cloudfrontClient.UpdateFunction(ctx, &cloudfront.UpdateFunctionInput{
+			Name:           aws.String(name),
+			IfMatch:        describeOut.ETag,
+			FunctionConfig: functionConfig,
+			FunctionCode:   []byte(code),
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed updating CloudFront function '%s': %w", name, err)
+		}
+		etag = updateOut.ETag
+	case errors.As(err, &noSuchFunction):
+		createOut, err := cloudfrontClient.CreateFunction(ctx, &cloudfront.CreateFunctionInput{
+			Name:           aws.String(name),
+			FunctionConfig: functionConfig,
+			FunctionCode:   []byte(code),
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed creating CloudFront function '%s': %w", name, err)
+		}
+		etag = createOut.ETag
+	default:
+		return "", fmt.Errorf("failed looking up CloudFront function '%s': %w", name, err)
+	}
+
+	publishOut, err := cloudfrontClient.PublishFunction(ctx, &cloudfront.PublishFunctionInput{
+		Name:    aws.String(name),
+		IfMatch: etag,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed publishing CloudFront function '%s': %w", name, err)
+	}
+	if publishOut.FunctionSummary == nil || publishOut.FunctionSummary.FunctionMetadata == nil || publishOut.FunctionSummary.FunctionMetadata.FunctionARN == nil {
+		return "", errors.New("unexpected empty function response")
+	}
+	return *publishOut.FunctionSummary.FunctionMetadata.FunctionARN, nil
+}
+
+// viewerRequestFunctionAssociations attaches functionArn (when set) as the viewer-request function
+func viewerRequestFunctionAssociations(functionArn string) *cloudfrontTypes.FunctionAssociations {
+	if functionArn == "" {
+		return &cloudfrontTypes.FunctionAssociations{Quantity: aws.Int32(0)}
+	}
+	return &cloudfrontTypes.FunctionAssociations{
+		Quantity: aws.Int32(1),
+		Items: []cloudfrontTypes.FunctionAssociation{
+			{
+				EventType:   cloudfrontTypes.EventTypeViewerRequest,
+				FunctionARN: aws.String(functionArn),
+			},
+		},
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// builtinContentTypes is used instead of the host's mime database so that uploads get the
+// same metadata on every machine
+var builtinContentTypes = map[string]string{
+	".html":        "text/html",
+	".htm":         "text/html",
+	".css":         "text/css",
+	".js":          "text/javascript",
+	".mjs":         "text/javascript",
+	".cjs":         "text/javascript",
+	".json":        "application/json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".xml":         "application/xml",
+	".rss":         "application/rss+xml",
+	".atom":        "application/atom+xml",
+	".txt":         "text/plain",
+	".md":          "text/markdown",
+	".csv":         "text/csv",
+	".ics":         "text/calendar",
+	".yaml":        "application/yaml",
+	".yml":         "application/yaml",
+	".svg":         "image/svg+xml",
+	".png":         "image/png",
+	".jpg":         "image/jpeg",
+	".jpeg":        "image/jpeg",
+	".gif":         "image/gif",
+	".webp":        "image/webp",
+	".avif":        "image/avif",
+	".ico":         "image/x-icon",
+	".bmp":         "image/bmp",
+	".wasm":        "application/wasm",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".ttf":         "font/ttf",
+	".otf":         "font/otf",
+	".eot":         "application/vnd.ms-fontobject",
+	".mp4":         "video/mp4",
+	".webm":        "video/webm",
+	".ogv":         "video/ogg",
+	".mp3":         "audio/mpeg",
+	".ogg":         "audio/ogg",
+	".wav":         "audio/wav",
+	".pdf":         "application/pdf",
+	".zip":         "application/zip",
+	".gz":          "application/gzip",
+	".tar":         "application/x-tar",
+}
+
+// precompressedEncodings maps suffixes of precompressed assets (app.js.gz) to their Content-Encoding
+var precompressedEncodings = map[string]string{
+	".gz": "gzip",
+	".br": "br",
+}
+
+type ContentMetadata struct {
+	ContentType     string
+	ContentEncoding string
+}
+
+type ContentTypeResolver struct {
+	overrides map[string]string
+}
+
+func NewContentTypeResolver(overrides map[string]string) *ContentTypeResolver {
+	normalized := make(map[string]string, len(overrides))
+	for extension, contentType := range overrides {
+		extension = strings.ToLower(extension)
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+		normalized[extension] = contentType
+	}
+	return &ContentTypeResolver{overrides: normalized}
+}
+
+// Resolve determines the metadata for the file at fullPath, published as repoPath. The
+// extension decides first; the file's leading bytes are only sniffed when it is unknown.
+func (resolver ContentTypeResolver) Resolve(repoPath string, fullPath string) (ContentMetadata, error) {
+	extension := strings.ToLower(path.Ext(repoPath))
+	// Only treat .gz/.br as an encoding when the inner file is a known web format, so that
+	// downloads such as archive.tar.gz keep their archive type
+	if encoding, ok := precompressedEncodings[extension]; ok {
+		innerExtension := strings.ToLower(path.Ext(strings.TrimSuffix(repoPath, path.Ext(repoPath))))
+		if contentType, ok := resolver.lookup(innerExtension); ok && innerExtension != ".tar" {
+			return ContentMetadata{ContentType: withCharset(contentType), ContentEncoding: encoding}, nil
+		}
+	}
+	if contentType, ok := resolver.lookup(extension); ok {
+		return ContentMetadata{ContentType: withCharset(contentType)}, nil
+	}
+
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return ContentMetadata{}, err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return ContentMetadata{}, err
+	}
+	return ContentMetadata{ContentType: withCharset(http.DetectContentType(head[:n]))}, nil
+}
+
+func (resolver ContentTypeResolver) lookup(extension string) (string, bool) {
+	if extension == "" {
+		return "", false
+	}
+	if contentType, ok := resolver.overrides[extension]; ok {
+		return contentType, true
+	}
+	contentType, ok := builtinContentTypes[extension]
+	return contentType, ok
+}
+
+// withCharset marks textual types as UTF-8 unless a charset is already present
+func withCharset(contentType string) string {
+	if strings.Contains(contentType, "charset=") {
+		return contentType
+	}
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	isText := strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/json" ||
+		mediaType == "application/xml" ||
+		mediaType == "application/javascript" ||
+		mediaType == "application/yaml"
+	if !isText {
+		return contentType
+	}
+	return mediaType + "; charset=utf-8"
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	cloudfrontTypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+// DeployOptions holds the command line settings that tune how a site is deployed. Backends
+// that cannot honor a setting ignore it.
+type DeployOptions struct {
+	Region     string
+	BucketName string
+
+	BucketEncryption BucketEncryption
+	KmsKeyId         string
+	Tags             map[string]string
+
+	AccessLogs             bool
+	AccessLogBucket        string
+	AccessLogPrefix        string
+	AccessLogRetentionDays int
+
+	ContentTypeOverrides map[string]string
+	CacheControlRules    CacheControlRules
+
+	InvalidationPathThreshold int
+	WaitForInvalidation       bool
+
+	UploadConcurrency       int
+	MultipartThresholdBytes int64
+	UploadRetries           int
+
+	AtomicReleases bool
+	KeepReleases   int
+
+	SiteMode            SiteMode
+	PrettyUrls          bool
+	StripHtmlExtensions bool
+
+	SecurityHeaders       SecurityHeadersProfile
+	ContentSecurityPolicy string
+
+	BasicAuthCredentials []BasicAuthCredential
+
+	CachePolicyId         string
+	OriginRequestPolicyId string
+	PriceClass            cloudfrontTypes.PriceClass
+	HttpVersion           cloudfrontTypes.HttpVersion
+	Ipv6Enabled           bool
+}
+
+// SiteMode decides how CloudFront answers requests for paths that have no object
+type SiteMode string
+
+const (
+	SiteModeDefault SiteMode = ""
+	// SiteModeSpa serves /index.html with a 200 so client-side routers handle deep links
+	SiteModeSpa SiteMode = "spa"
+	// SiteModeStatic serves the site's own /404.html with a 404 status
+	SiteModeStatic SiteMode = "static"
+)
+
+// RequiredPage is the page the mode serves for missing paths, if any
+func (siteMode SiteMode) RequiredPage() string {
+	switch siteMode {
+	case SiteModeSpa:
+		return "index.html"
+	case SiteModeStatic:
+		return "404.html"
+	}
+	return ""
+}
+
+// keyValueFlag collects repeated "key=value" flags into a map
+type keyValueFlag map[string]string
+
+func (keyValues keyValueFlag) String() string {
+	var pairs []string
+	for key, value := range keyValues {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (keyValues keyValueFlag) Set(value string) error {
+	key, val, found := strings.Cut(value, "=")
+	if !found || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("expected key=value, got '%s'", value)
+	}
+	keyValues[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	return nil
+}
+
+// ParseDeployOptions parses the deploy flags and returns the remaining positional arguments
+func ParseDeployOptions(args []string) (DeployOptions, []string, error) {
+	options := DeployOptions{
+		ContentTypeOverrides: map[string]string{},
+		Tags:                 map[string]string{},
+	}
+	flagSet := flag.NewFlagSet("hostit", flag.ContinueOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), "Usage: hostit [options] <domain_name> <folder_name>")
+		fmt.Fprintln(flagSet.Output(), "       hostit releases <domain_name>")
+		fmt.Fprintln(flagSet.Output(), "       hostit rollback [-to <release>] <domain_name>")
+		fmt.Fprintln(flagSet.Output(), "       hostit stats [-since <duration>] <domain_name>")
+		flagSet.PrintDefaults()
+	}
+	flagSet.StringVar(&options.Region, "region", "", "AWS region for the S3 bucket (default: the AWS config region, or us-east-1)")
+	flagSet.StringVar(&options.BucketName, "bucket-name", "", "S3 bucket name (default: '<account>-<domain>-hostit', shortened with a hash when too long)")
+	bucketEncryption := flagSet.String("encryption", "", "S3 bucket default encryption: sse-s3 or sse-kms (default: leave the bucket's setting, SSE-S3 for new buckets)")
+	flagSet.StringVar(&options.KmsKeyId, "kms-key-id", "", "customer managed KMS key for sse-kms encryption; implies -encryption sse-kms")
+	flagSet.Var(keyValueFlag(options.Tags), "tag", "tag the S3 bucket, CloudFront distribution and ACM certificate, e.g. cost-center=web (repeatable)")
+	flagSet.BoolVar(&options.AccessLogs, "access-logs", false, "write CloudFront standard logs to an S3 log bucket, for 'hostit stats'")
+	flagSet.StringVar(&options.AccessLogBucket, "access-log-bucket", "", "S3 bucket for CloudFront logs (default: '<account>-logs-<domain>-hostit', created when missing)")
+	flagSet.StringVar(&options.AccessLogPrefix, "access-log-prefix", "", "key prefix of the CloudFront logs in the log bucket (default: '<domain>/')")
+	flagSet.IntVar(&options.AccessLogRetentionDays, "access-log-retention-days", 90, "days CloudFront logs are kept before S3 expires them (0 keeps them forever)")
+	flagSet.Var(keyValueFlag(options.ContentTypeOverrides), "content-type", "override the Content-Type for an extension, e.g. .webmanifest=application/manifest+json (repeatable)")
+	flagSet.Var(&options.CacheControlRules, "cache-control", "set Cache-Control for matching paths, e.g. '*.html=no-cache' or 'assets/**=public, max-age=31536000, immutable' (repeatable, first match wins)")
+	flagSet.IntVar(&options.InvalidationPathThreshold, "invalidation-threshold", 15, "invalidate '/*' instead of individual paths when more than this many paths changed")
+	flagSet.BoolVar(&options.WaitForInvalidation, "wait-invalidation", false, "wait for the CloudFront invalidation to complete")
+	flagSet.IntVar(&options.UploadConcurrency, "upload-concurrency", 8, "number of files uploaded to S3 in parallel")
+	multipartThresholdMb := flagSet.Int64("multipart-threshold-mb", 64, "upload S3 files larger than this many MiB in multipart chunks of this size")
+	flagSet.IntVar(&options.UploadRetries, "upload-retries", 3, "times a failed S3 file upload is retried with backoff")
+	flagSet.BoolVar(&options.AtomicReleases, "atomic-releases", false, "upload each S3 deploy under releases/<id>/ and switch CloudFront to it in one update")
+	flagSet.IntVar(&options.KeepReleases, "keep-releases", 5, "number of S3 releases that can be rolled back to, including the live one; older object versions and atomic releases are deleted, as are older SFTP release directories")
+	spa := flagSet.Bool("spa", false, "single-page application: serve /index.html with status 200 for missing paths")
+	custom404 := flagSet.Bool("custom-404", false, "static site: serve /404.html with status 404 for missing paths")
+	flagSet.BoolVar(&options.PrettyUrls, "pretty-urls", true, "serve dir/index.html for /dir/ and redirect /dir to /dir/ when dir/index.html exists, through a CloudFront Function")
+	flagSet.BoolVar(&options.StripHtmlExtensions, "strip-html", false, "serve page.html for /page and redirect /page.html to /page (directories then need a trailing slash)")
+	securityHeaders := flagSet.String("security-headers", string(SecurityHeadersDefault), "security response headers added by CloudFront: strict, default or none")
+	flagSet.Var(basicAuthFlag{credentials: &options.BasicAuthCredentials}, "basic-auth", "require HTTP basic auth with user:password on the S3 site (repeatable; visible in the process list, prefer -basic-auth-file)")
+	basicAuthFile := flagSet.String("basic-auth-file", "", "file with one user:password per line to require as HTTP basic auth on the S3 site")
+	flagSet.StringVar(&options.ContentSecurityPolicy, "csp", "", "Content-Security-Policy sent with every response, replacing the profile's own")
+	flagSet.StringVar(&options.CachePolicyId, "cache-policy-id", "", "CloudFront cache policy for paths without a Cache-Control rule, e.g. the managed CachingOptimized policy 658327ea-f89d-4fab-a63d-7e88639e58f6 (default: a hostit policy honoring origin Cache-Control)")
+	flagSet.StringVar(&options.OriginRequestPolicyId, "origin-request-policy-id", "", "CloudFront origin request policy attached to every cache behavior")
+	priceClass := flagSet.String("price-class", "All", "CloudFront price class: All, 200 or 100")
+	httpVersion := flagSet.String("http-version", string(cloudfrontTypes.HttpVersionHttp2and3), "highest HTTP version CloudFront serves: http1.1, http2, http3 or http2and3")
+	flagSet.BoolVar(&options.Ipv6Enabled, "ipv6", true, "serve the CloudFront distribution over IPv6")
+	err := flagSet.Parse(args)
+	if err != nil {
+		return options, nil, err
+	}
+	options.MultipartThresholdBytes = *multipartThresholdMb * 1024 * 1024
+	if options.BucketName != "" {
+		if err := ValidateBucketName(options.BucketName); err != nil {
+			return options, nil, err
+		}
+	}
+	options.BucketEncryption, err = ParseBucketEncryption(*bucketEncryption, options.KmsKeyId)
+	if err != nil {
+		return options, nil, err
+	}
+	if err := ValidateResourceTags(options.Tags); err != nil {
+		return options, nil, err
+	}
+	if options.AccessLogBucket != "" {
+		if err := ValidateBucketName(options.AccessLogBucket); err != nil {
+			return options, nil, err
+		}
+	}
+	if options.AccessLogRetentionDays < 0 {
+		return options, nil, errors.New("-access-log-retention-days must not be negative")
+	}
+	if options.KeepReleases < 1 {
+		return options, nil, errors.New("-keep-releases must be at least 1 so the live release stays recorded")
+	}
+	if options.AtomicReleases && options.KeepReleases < 2 {
+		return options, nil, errors.New("-keep-releases must be at least 2 so the previous release stays available while CloudFront switches")
+	}
+	if *spa && *custom404 {
+		return options, nil, errors.New("-spa and -custom-404 cannot be combined")
+	}
+	if *spa {
+		options.SiteMode = SiteModeSpa
+	} else if *custom404 {
+		options.SiteMode = SiteModeStatic
+	}
+	if *basicAuthFile != "" {
+		credentials, err := LoadBasicAuthFile(*basicAuthFile)
+		if err != nil {
+			return options, nil, err
+		}
+		options.BasicAuthCredentials = append(options.BasicAuthCredentials, credentials...)
+	}
+	options.SecurityHeaders, err = ParseSecurityHeadersProfile(*securityHeaders)
+	if err != nil {
+		return options, nil, err
+	}
+	if options.SecurityHeaders == SecurityHeadersNone && options.ContentSecurityPolicy != "" {
+		return options, nil, errors.New("-csp requires a security headers profile other than none")
+	}
+	options.PriceClass, err = ParsePriceClass(*priceClass)
+	if err != nil {
+		return options, nil, err
+	}
+	options.HttpVersion, err = ParseHttpVersion(*httpVersion)
+	if err != nil {
+		return options, nil, err
+	}
+	return options, flagSet.Args(), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DeploymentState records the resources hostit created for a domain so that later runs can
+// update the same site instead of creating a new one. It is kept per machine under the user
+// config directory, or HOSTIT_STATE_DIR when set.
+type DeploymentState struct {
+	Domain                 string    `json:"domain"`
+	Backend                string    `json:"backend"`
+	BucketName             string    `json:"bucketName,omitempty"`
+	Region                 string    `json:"region,omitempty"`
+	DistributionId         string    `json:"distributionId,omitempty"`
+	DistributionDomainName string    `json:"distributionDomainName,omitempty"`
+	CertificateArn         string    `json:"certificateArn,omitempty"`
+	Repository             string    `json:"repository,omitempty"`
+	LogBucket              string    `json:"logBucket,omitempty"`
+	LogPrefix              string    `json:"logPrefix,omitempty"`
+	ReleaseId              string    `json:"releaseId,omitempty"`
+	RootCid                string    `json:"rootCid,omitempty"`
+	UpdatedAt              time.Time `json:"updatedAt"`
+}
+
+func deploymentStateDir() (string, error) {
+	if stateDir := os.Getenv("HOSTIT_STATE_DIR"); stateDir != "" {
+		return stateDir, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to locate config directory: %w", err)
+	}
+	return filepath.Join(configDir, "hostit", "deployments"), nil
+}
+
+// LoadDeploymentState returns the saved state for domainName, or nil if there is none
+func LoadDeploymentState(domainName string) (*DeploymentState, error) {
+	stateDir, err := deploymentStateDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(stateDir, domainName+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deployment state: %w", err)
+	}
+	var state DeploymentState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse deployment state: %w", err)
+	}
+	return &state, nil
+}
+
+func (state *DeploymentState) Save() error {
+	if state.Domain == "" {
+		return errors.New("deployment state has no domain")
+	}
+	stateDir, err := deploymentStateDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	state.UpdatedAt = time.Now().UTC()
+	encoded, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(stateDir, state.Domain+".json"), append(encoded, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write deployment state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+const (
+	defaultGitLabBaseUrl     = "https://gitlab.com"
+	defaultGitLabPagesDomain = "gitlab.io"
+	gitLabBranchName         = "main"
+)
+
+// gitLabPagesCiConfig publishes the committed public/ folder as-is without a build step
+const gitLabPagesCiConfig = `pages:
+  stage: deploy
+  script:
+    - echo "Publishing content hosted through hostit"
+  artifacts:
+    paths:
+      - public
+  rules:
+    - if: $CI_COMMIT_BRANCH == $CI_DEFAULT_BRANCH
+`
+
+type GitLabObjectStorageProviderManager struct {
+	domainName       string
+	folderName       string
+	projectPath      string
+	baseUrl          string
+	pagesDomain      string
+	apiClient        *jsonApiClient
+	namespace        string
+	projectId        int64
+	verificationCode string
+	deploymentState  *DeploymentState
+}
+
+type gitLabUser struct {
+	Username string `json:"username"`
+}
+
+type gitLabProject struct {
+	Id                int64  `json:"id"`
+	PathWithNamespace string `json:"path_with_namespace"`
+}
+
+type gitLabPagesDomain struct {
+	Domain           string `json:"domain"`
+	VerificationCode string `json:"verification_code"`
+}
+
+type gitLabCommitAction struct {
+	Action   string `json:"action"`
+	FilePath string `json:"file_path"`
+	Content  string `json:"content,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type gitLabTreeEntry struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) InstantiateClient() error {
+	token := os.Getenv("GITLAB_TOKEN")
+	if token == "" {
+		return errors.New("GITLAB_TOKEN not set")
+	}
+	// Self-managed instances set their own base URL and Pages domain
+	if baseUrl := os.Getenv("GITLAB_URL"); baseUrl != "" {
+		gitLabObjectStorageProviderManager.baseUrl = strings.TrimSuffix(baseUrl, "/")
+	}
+	if pagesDomain := os.Getenv("GITLAB_PAGES_DOMAIN"); pagesDomain != "" {
+		gitLabObjectStorageProviderManager.pagesDomain = pagesDomain
+	}
+	gitLabObjectStorageProviderManager.apiClient = newJsonApiClient("gitlab", gitLabObjectStorageProviderManager.baseUrl+"/api/v4", "PRIVATE-TOKEN", token)
+
+	var user gitLabUser
+	_, err := gitLabObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodGet, "/user", nil, &user)
+	if err != nil || user.Username == "" {
+		return fmt.Errorf("failed to determine authenticated gitlab user: %w", err)
+	}
+	gitLabObjectStorageProviderManager.namespace = user.Username
+	fmt.Printf("Using GitLab user %s at %s for object storage provider\n", user.Username, gitLabObjectStorageProviderManager.baseUrl)
+
+	deploymentState, err := LoadDeploymentState(gitLabObjectStorageProviderManager.domainName)
+	if err != nil {
+		return err
+	}
+	if deploymentState != nil && deploymentState.Backend == "gitlab" {
+		gitLabObjectStorageProviderManager.deploymentState = deploymentState
+		if namespace, projectPath, ok := strings.Cut(deploymentState.Repository, "/"); ok {
+			gitLabObjectStorageProviderManager.namespace, gitLabObjectStorageProviderManager.projectPath = namespace, projectPath
+		}
+	}
+	return nil
+}
+
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) VerifyNamespace() (bool, error) {
+	// A project hostit deployed before is updated in place
+	if gitLabObjectStorageProviderManager.deploymentState != nil {
+		return true, nil
+	}
+	statusCode, err := gitLabObjectStorageProviderManager.findProject()
+	if statusCode == http.StatusNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("error checking project: %w", err)
+	}
+	return false, nil
+}
+
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) ExistingDeployment() string {
+	return fmt.Sprintf("Project %s already exists", gitLabObjectStorageProviderManager.project())
+}
+
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) AdoptExistingDeployment() error {
+	deploymentState := &DeploymentState{
+		Domain:     gitLabObjectStorageProviderManager.domainName,
+		Backend:    "gitlab",
+		Repository: gitLabObjectStorageProviderManager.project(),
+	}
+	if err := deploymentState.Save(); err != nil {
+		return err
+	}
+	gitLabObjectStorageProviderManager.deploymentState = deploymentState
+	return nil
+}
+
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) CreateStorageInstance() error {
+	if gitLabObjectStorageProviderManager.deploymentState != nil {
+		if _, err := gitLabObjectStorageProviderManager.findProject(); err != nil {
+			return fmt.Errorf("failed to look up gitlab project %s: %w", gitLabObjectStorageProviderManager.project(), err)
+		}
+		return nil
+	}
+	var project gitLabProject
+	_, err := gitLabObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodPost, "/projects", map[string]any{
+		"name":               gitLabObjectStorageProviderManager.domainName,
+		"path":               gitLabObjectStorageProviderManager.projectPath,
+		"description":        "Hosted through hostit",
+		"visibility":         "public",
+		"pages_access_level": "public",
+	}, &project)
+	if err != nil {
+		return fmt.Errorf("failed to create gitlab project: %w", err)
+	}
+	if project.Id == 0 {
+		return errors.New("unexpected empty project id from gitlab")
+	}
+	gitLabObjectStorageProviderManager.projectId = project.Id
+	return nil
+}
+
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) UploadFilesToNewInstance() error {
+	// 100 MB
+	const maxFileSizeBytes = 100 * 1024 * 1024
+
+	if gitLabObjectStorageProviderManager.projectId == 0 {
+		return errors.New("gitlab project not created; call CreateStorageInstance first")
+	}
+
+	finder := NewUploadFileFinder()
+	filesToUpload, err := finder.FindFiles(gitLabObjectStorageProviderManager.folderName, maxFileSizeBytes)
+	if err != nil {
+		return err
+	}
+
+	existingShas, err := gitLabObjectStorageProviderManager.listBranchFiles()
+	if err != nil {
+		return err
+	}
+
+	// GitLab Pages serves the artifacts of the pages job, so the site lives under public/
+	projectFiles := make(map[string][]byte, len(filesToUpload)+1)
+	for _, repoPath := range filesToUpload {
+		fullPath := filepath.Join(gitLabObjectStorageProviderManager.folderName, filepath.FromSlash(repoPath))
+		data, err := os.ReadFile(fullPath)
+		if err != nil {
+			return fmt.Errorf("failed to read file '%s': %w", fullPath, err)
+		}
+		projectFiles["public/"+repoPath] = data
+		log.Printf("Found '%s'", repoPath)
+	}
+	projectFiles[".gitlab-ci.yml"] = []byte(gitLabPagesCiConfig)
+
+	// Files already on the branch are updated when their content changed, and removed when they
+	// are no longer part of the site
+	var actions []gitLabCommitAction
+	for filePath, data := range projectFiles {
+		existingSha, exists := existingShas[filePath]
+		switch {
+		case !exists:
+			actions = append(actions, gitLabCommitAction{Action: "create", FilePath: filePath, Content: base64.StdEncoding.EncodeToString(data), Encoding: "base64"})
+		case existingSha != gitBlobSha(data):
+			actions = append(actions, gitLabCommitAction{Action: "update", FilePath: filePath, Content: base64.StdEncoding.EncodeToString(data), Encoding: "base64"})
+		}
+	}
+	for filePath := range existingShas {
+		if _, ok := projectFiles[filePath]; !ok {
+			actions = append(actions, gitLabCommitAction{Action: "delete", FilePath: filePath})
+		}
+	}
+	if len(actions) == 0 {
+		fmt.Println("Pages content is unchanged")
+		return nil
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].FilePath < actions[j].FilePath
+	})
+
+	_, err = gitLabObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodPost, gitLabObjectStorageProviderManager.projectApiPath()+"/repository/commits", map[string]any{
+		"branch":         gitLabBranchName,
+		"commit_message": "GitLab pages content",
+		"actions":        actions,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create commit: %w", err)
+	}
+	return nil
+}
+
+// listBranchFiles maps the path of each file on the default branch to its blob sha. An empty
+// repository has no files.
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) listBranchFiles() (map[string]string, error) {
+	const pageSize = 100
+	shas := map[string]string{}
+	for page := 1; ; page++ {
+		var entries []gitLabTreeEntry
+		query := url.Values{
+			"ref":       {gitLabBranchName},
+			"recursive": {"true"},
+			"page":      {strconv.Itoa(page)},
+			"per_page":  {strconv.Itoa(pageSize)},
+		}
+		statusCode, err := gitLabObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodGet, gitLabObjectStorageProviderManager.projectApiPath()+"/repository/tree?"+query.Encode(), nil, &entries)
+		if statusCode == http.StatusNotFound {
+			return shas, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files on the %s branch: %w", gitLabBranchName, err)
+		}
+		for _, entry := range entries {
+			if entry.Type == "blob" {
+				shas[entry.Path] = entry.Id
+			}
+		}
+		if len(entries) < pageSize {
+			return shas, nil
+		}
+	}
+}
+
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) CreateAvailableDomain() error {
+	if gitLabObjectStorageProviderManager.projectId == 0 {
+		return errors.New("gitlab project not created")
+	}
+	// A project updated in place already has the domain
+	var domain gitLabPagesDomain
+	domainPath := gitLabObjectStorageProviderManager.projectApiPath() + "/pages/domains/" + url.PathEscape(gitLabObjectStorageProviderManager.domainName)
+	statusCode, err := gitLabObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodGet, domainPath, nil, &domain)
+	switch {
+	case statusCode == http.StatusNotFound:
+		_, err = gitLabObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodPost, gitLabObjectStorageProviderManager.projectApiPath()+"/pages/domains", map[string]any{
+			"domain":           gitLabObjectStorageProviderManager.domainName,
+			"auto_ssl_enabled": true,
+		}, &domain)
+		if err != nil {
+			return fmt.Errorf("failed to register gitlab pages domain: %w", err)
+		}
+		if domain.VerificationCode == "" {
+			// Older GitLab versions only return the code when the domain is fetched directly
+			_, err = gitLabObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodGet, domainPath, nil, &domain)
+			if err != nil {
+				return fmt.Errorf("failed to fetch gitlab pages domain: %w", err)
+			}
+		}
+	case err != nil:
+		return fmt.Errorf("failed to fetch gitlab pages domain: %w", err)
+	}
+	if domain.VerificationCode == "" {
+		return errors.New("gitlab did not return a pages verification code")
+	}
+	gitLabObjectStorageProviderManager.verificationCode = domain.VerificationCode
+
+	if gitLabObjectStorageProviderManager.deploymentState != nil {
+		return nil
+	}
+	deploymentState := &DeploymentState{
+		Domain:     gitLabObjectStorageProviderManager.domainName,
+		Backend:    "gitlab",
+		Repository: gitLabObjectStorageProviderManager.project(),
+	}
+	return deploymentState.Save()
+}
+
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) GetRequiredDnsRecords() ([]*types.ResourceRecordSet, error) {
+	if gitLabObjectStorageProviderManager.verificationCode == "" {
+		return nil, errors.New("gitlab pages domain not registered")
+	}
+	verificationValue := gitLabObjectStorageProviderManager.verificationCode
+	if !strings.HasPrefix(verificationValue, "gitlab-pages-verification-code=") {
+		verificationValue = "gitlab-pages-verification-code=" + verificationValue
+	}
+	return []*types.ResourceRecordSet{
+		{
+			Name: aws.String(gitLabObjectStorageProviderManager.domainName + "."),
+			Type: types.RRTypeCname,
+			TTL:  aws.Int64(300),
+			ResourceRecords: []types.ResourceRecord{
+				{
+					Value: aws.String(strings.ToLower(gitLabObjectStorageProviderManager.namespace) + "." + gitLabObjectStorageProviderManager.pagesDomain),
+				},
+			},
+		},
+		{
+			Name: aws.String("_gitlab-pages-verification-code." + gitLabObjectStorageProviderManager.domainName + "."),
+			Type: types.RRTypeTxt,
+			TTL:  aws.Int64(300),
+			ResourceRecords: []types.ResourceRecord{
+				{
+					Value: aws.String(strconv.Quote(verificationValue)),
+				},
+			},
+		},
+	}, nil
+}
+
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) FinalizeHttps() error {
+	return nil
+}
+
+// findProject looks up the project by its path and records its id
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) findProject() (int, error) {
+	var project gitLabProject
+	statusCode, err := gitLabObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodGet, "/projects/"+url.PathEscape(gitLabObjectStorageProviderManager.project()), nil, &project)
+	if err != nil {
+		return statusCode, err
+	}
+	gitLabObjectStorageProviderManager.projectId = project.Id
+	return statusCode, nil
+}
+
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) project() string {
+	return gitLabObjectStorageProviderManager.namespace + "/" + gitLabObjectStorageProviderManager.projectPath
+}
+
+func (gitLabObjectStorageProviderManager *GitLabObjectStorageProviderManager) projectApiPath() string {
+	return "/projects/" + strconv.FormatInt(gitLabObjectStorageProviderManager.projectId, 10)
+}
+
+func NewGitLabObjectStorageProviderManager(domainName string, folderName string) (*GitLabObjectStorageProviderManager, error) {
+	if folderName == "" {
+		return nil, errors.New("folderName must not be empty")
+	}
+	return &GitLabObjectStorageProviderManager{
+		domainName:       domainName,
+		folderName:       folderName,
+		projectPath:      strings.ToLower(strings.ReplaceAll(domainName, ".", "-")),
+		baseUrl:          defaultGitLabBaseUrl,
+		pagesDomain:      defaultGitLabPagesDomain,
+		apiClient:        nil,
+		namespace:        "",
+		projectId:        0,
+		verificationCode: "",
+		deploymentState:  nil,
+	}, nil
+}
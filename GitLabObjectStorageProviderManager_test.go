@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// fakeGitLab keeps the files of the main branch of one project and its pages domain
+type fakeGitLab struct {
+	projectCreated bool
+	files          map[string]string
+	commits        [][]gitLabCommitAction
+	domain         *gitLabPagesDomain
+}
+
+func (fake *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("PRIVATE-TOKEN") != "test-token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch route := r.Method + " " + r.URL.EscapedPath(); {
+	case route == "GET /api/v4/user":
+		json.NewEncoder(w).Encode(gitLabUser{Username: "alice"})
+	case route == "GET /api/v4/projects/alice%2Fwww-example-com":
+		if !fake.projectCreated {
+			http.Error(w, `{"message":"404 Project Not Found"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(gitLabProject{Id: 7, PathWithNamespace: "alice/www-example-com"})
+	case route == "POST /api/v4/projects":
+		fake.projectCreated = true
+		json.NewEncoder(w).Encode(gitLabProject{Id: 7, PathWithNamespace: "alice/www-example-com"})
+	case route == "GET /api/v4/projects/7/repository/tree":
+		if len(fake.files) == 0 {
+			http.Error(w, `{"message":"404 Tree Not Found"}`, http.StatusNotFound)
+			return
+		}
+		entries := []gitLabTreeEntry{{Type: "tree", Path: "public"}}
+		for path, content := range fake.files {
+			entries = append(entries, gitLabTreeEntry{Id: gitBlobSha([]byte(content)), Type: "blob", Path: path})
+		}
+		json.NewEncoder(w).Encode(entries)
+	case route == "POST /api/v4/projects/7/repository/commits":
+		var body struct {
+			Branch  string               `json:"branch"`
+			Actions []gitLabCommitAction `json:"actions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Branch != gitLabBranchName {
+			http.Error(w, "bad commit", http.StatusBadRequest)
+			return
+		}
+		for _, action := range body.Actions {
+			content, _ := base64.StdEncoding.DecodeString(action.Content)
+			if action.Action == "delete" {
+				delete(fake.files, action.FilePath)
+			} else {
+				fake.files[action.FilePath] = string(content)
+			}
+		}
+		fake.commits = append(fake.commits, body.Actions)
+		w.WriteHeader(http.StatusCreated)
+	case route == "GET /api/v4/projects/7/pages/domains/www.example.com":
+		if fake.domain == nil {
+			http.Error(w, `{"message":"404 Not found"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(fake.domain)
+	case route == "POST /api/v4/projects/7/pages/domains":
+		fake.domain = &gitLabPagesDomain{Domain: "www.example.com", VerificationCode: "abc123"}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(fake.domain)
+	default:
+		http.Error(w, "unexpected request", http.StatusNotImplemented)
+	}
+}
+
+func deployToFakeGitLab(t *testing.T, folderName string) *GitLabObjectStorageProviderManager {
+	t.Helper()
+	manager, err := NewGitLabObjectStorageProviderManager("www.example.com", folderName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.InstantiateClient(); err != nil {
+		t.Fatal(err)
+	}
+	available, err := manager.VerifyNamespace()
+	if err != nil || !available {
+		t.Fatalf("VerifyNamespace() = %v, %v; want the project created or updated", available, err)
+	}
+	for _, step := range []func() error{manager.CreateStorageInstance, manager.UploadFilesToNewInstance, manager.CreateAvailableDomain} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return manager
+}
+
+func TestGitLabDeployAndUpdate(t *testing.T) {
+	fake := &fakeGitLab{files: map[string]string{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	t.Setenv("GITLAB_TOKEN", "test-token")
+	t.Setenv("GITLAB_URL", server.URL)
+	t.Setenv("HOSTIT_STATE_DIR", t.TempDir())
+
+	manager := deployToFakeGitLab(t, writeTestSite(t, map[string]string{
+		"index.html": "first",
+		"old.html":   "old",
+	}))
+	wantFiles := map[string]string{
+		".gitlab-ci.yml":    gitLabPagesCiConfig,
+		"public/index.html": "first",
+		"public/old.html":   "old",
+	}
+	if !reflect.DeepEqual(fake.files, wantFiles) {
+		t.Errorf("files = %v; want %v", fake.files, wantFiles)
+	}
+	records, err := manager.GetRequiredDnsRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || *records[0].ResourceRecords[0].Value != "alice.gitlab.io" || *records[1].ResourceRecords[0].Value != `"gitlab-pages-verification-code=abc123"` {
+		t.Errorf("records = %v", records)
+	}
+
+	// The second deploy finds the project through the saved state and commits only the changes
+	deployToFakeGitLab(t, writeTestSite(t, map[string]string{
+		"index.html": "second",
+	}))
+	wantActions := []gitLabCommitAction{
+		{Action: "update", FilePath: "public/index.html", Content: base64.StdEncoding.EncodeToString([]byte("second")), Encoding: "base64"},
+		{Action: "delete", FilePath: "public/old.html"},
+	}
+	if len(fake.commits) != 2 || !reflect.DeepEqual(fake.commits[1], wantActions) {
+		t.Errorf("commits = %+v; want %+v", fake.commits, wantActions)
+	}
+	if fake.files["public/index.html"] != "second" || len(fake.files) != 2 {
+		t.Errorf("files = %v", fake.files)
+	}
+}
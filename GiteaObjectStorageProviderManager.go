@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+const (
+	defaultGiteaBaseUrl     = "https://codeberg.org"
+	defaultGiteaPagesDomain = "codeberg.page"
+	giteaPagesBranchName    = "pages"
+)
+
+// GiteaObjectStorageProviderManager publishes through Gitea-compatible forges (Gitea, Forgejo,
+// Codeberg) whose pages server serves the "pages" branch of a repository.
+type GiteaObjectStorageProviderManager struct {
+	domainName      string
+	folderName      string
+	repositoryName  string
+	repositoryOwner string
+	baseUrl         string
+	pagesDomain     string
+	apiClient       *jsonApiClient
+	deploymentState *DeploymentState
+}
+
+type giteaUser struct {
+	Login string `json:"login"`
+}
+
+// giteaFileOperation is one change of a commit made through the contents API. Updates and
+// deletions name the blob they replace.
+type giteaFileOperation struct {
+	Operation string `json:"operation"`
+	Path      string `json:"path"`
+	Content   string `json:"content,omitempty"`
+	Sha       string `json:"sha,omitempty"`
+}
+
+type giteaTree struct {
+	Entries    []giteaTreeEntry `json:"tree"`
+	Truncated  bool             `json:"truncated"`
+	TotalCount int              `json:"total_count"`
+}
+
+type giteaTreeEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Sha  string `json:"sha"`
+}
+
+func (giteaObjectStorageProviderManager *GiteaObjectStorageProviderManager) InstantiateClient() error {
+	token := os.Getenv("GITEA_TOKEN")
+	if token == "" {
+		return errors.New("GITEA_TOKEN not set")
+	}
+	if baseUrl := os.Getenv("GITEA_URL"); baseUrl != "" {
+		giteaObjectStorageProviderManager.baseUrl = strings.TrimSuffix(baseUrl, "/")
+	}
+	if pagesDomain := os.Getenv("GITEA_PAGES_DOMAIN"); pagesDomain != "" {
+		giteaObjectStorageProviderManager.pagesDomain = pagesDomain
+	}
+	giteaObjectStorageProviderManager.apiClient = newJsonApiClient("gitea", giteaObjectStorageProviderManager.baseUrl+"/api/v1", "Authorization", "token "+token)
+
+	var user giteaUser
+	_, err := giteaObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodGet, "/user", nil, &user)
+	if err != nil || user.Login == "" {
+		return fmt.Errorf("failed to determine authenticated gitea user: %w", err)
+	}
+	giteaObjectStorageProviderManager.repositoryOwner = user.Login
+	fmt.Printf("Using Gitea user %s at %s for object storage provider\n", user.Login, giteaObjectStorageProviderManager.baseUrl)
+
+	deploymentState, err := LoadDeploymentState(giteaObjectStorageProviderManager.domainName)
+	if err != nil {
+		return err
+	}
+	if deploymentState != nil && deploymentState.Backend == "gitea" {
+		giteaObjectStorageProviderManager.deploymentState = deploymentState
+		if owner, name, ok := strings.Cut(deploymentState.Repository, "/"); ok {
+			giteaObjectStorageProviderManager.repositoryOwner, giteaObjectStorageProviderManager.repositoryName = owner, name
+		}
+	}
+	return nil
+}
+
+func (giteaObjectStorageProviderManager *GiteaObjectStorageProviderManager) VerifyNamespace() (bool, error) {
+	// A repository hostit deployed before is updated in place
+	if giteaObjectStorageProviderManager.deploymentState != nil {
+		return true, nil
+	}
+	statusCode, err := giteaObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodGet, giteaObjectStorageProviderManager.repositoryApiPath(), nil, nil)
+	if statusCode == http.StatusNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("error checking repository: %w", err)
+	}
+	return false, nil
+}
+
+func (giteaObjectStorageProviderManager *GiteaObjectStorageProviderManager) ExistingDeployment() string {
+	return fmt.Sprintf("Repository %s already exists", giteaObjectStorageProviderManager.repository())
+}
+
+func (giteaObjectStorageProviderManager *GiteaObjectStorageProviderManager) AdoptExistingDeployment() error {
+	deploymentState := &DeploymentState{
+		Domain:     giteaObjectStorageProviderManager.domainName,
+		Backend:    "gitea",
+		Repository: giteaObjectStorageProviderManager.repository(),
+	}
+	if err := deploymentState.Save(); err != nil {
+		return err
+	}
+	giteaObjectStorageProviderManager.deploymentState = deploymentState
+	return nil
+}
+
+func (giteaObjectStorageProviderManager *GiteaObjectStorageProviderManager) CreateStorageInstance() error {
+	if giteaObjectStorageProviderManager.deploymentState != nil {
+		return nil
+	}
+	// The contents API can only commit to a branch that exists, so the repository starts with
+	// an initial commit on the pages branch
+	_, err := giteaObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodPost, "/user/repos", map[string]any{
+		"name":           giteaObjectStorageProviderManager.repositoryName,
+		"description":    "Hosted through hostit",
+		"private":        false,
+		"auto_init":      true,
+		"default_branch": giteaPagesBranchName,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create gitea repository: %w", err)
+	}
+	return nil
+}
+
+func (giteaObjectStorageProviderManager *GiteaObjectStorageProviderManager) UploadFilesToNewInstance() error {
+	// 100 MB
+	const maxFileSizeBytes = 100 * 1024 * 1024
+
+	if giteaObjectStorageProviderManager.repositoryOwner == "" {
+		return errors.New("repository owner not set; call InstantiateClient first")
+	}
+
+	finder := NewUploadFileFinder()
+	filesToUpload, err := finder.FindFiles(giteaObjectStorageProviderManager.folderName, maxFileSizeBytes)
+	if err != nil {
+		return err
+	}
+	existingShas, err := giteaObjectStorageProviderManager.listPagesBranchFiles()
+	if err != nil {
+		return err
+	}
+
+	siteFiles := make(map[string][]byte, len(filesToUpload)+1)
+	hasDomainsAtRoot := false
+	for _, repoPath := range filesToUpload {
+		fullPath := filepath.Join(giteaObjectStorageProviderManager.folderName, filepath.FromSlash(repoPath))
+		data, err := os.ReadFile(fullPath)
+		if err != nil {
+			return fmt.Errorf("failed to read file '%s': %w", fullPath, err)
+		}
+		// .domains is the pages server's equivalent of the GitHub Pages CNAME file
+		if repoPath == ".domains" {
+			hasDomainsAtRoot = true
+			current := strings.TrimSpace(string(data))
+			desired := strings.TrimSpace(giteaObjectStorageProviderManager.domainName)
+			if current != desired {
+				fmt.Printf("updating .domains content to '%s'\n", desired)
+				data = []byte(desired + "\n")
+			}
+		}
+		siteFiles[repoPath] = data
+		log.Printf("Found '%s'", repoPath)
+	}
+	if !hasDomainsAtRoot {
+		siteFiles[".domains"] = []byte(giteaObjectStorageProviderManager.domainName + "\n")
+	}
+
+	// Files already on the branch are updated when their content changed, and removed when they
+	// are no longer part of the site, such as the README of the initial commit
+	var files []giteaFileOperation
+	for repoPath, data := range siteFiles {
+		existingSha, exists := existingShas[repoPath]
+		switch {
+		case !exists:
+			files = append(files, giteaFileOperation{Operation: "create", Path: repoPath, Content: base64.StdEncoding.EncodeToString(data)})
+		case existingSha != gitBlobSha(data):
+			files = append(files, giteaFileOperation{Operation: "update", Path: repoPath, Content: base64.StdEncoding.EncodeToString(data), Sha: existingSha})
+		}
+	}
+	for repoPath, existingSha := range existingShas {
+		if _, ok := siteFiles[repoPath]; !ok {
+			files = append(files, giteaFileOperation{Operation: "delete", Path: repoPath, Sha: existingSha})
+		}
+	}
+	if len(files) == 0 {
+		fmt.Println("Pages content is unchanged")
+		return nil
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	_, err = giteaObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodPost, giteaObjectStorageProviderManager.repositoryApiPath()+"/contents", map[string]any{
+		"branch":  giteaPagesBranchName,
+		"message": "Pages content",
+		"files":   files,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to commit pages content: %w", err)
+	}
+	return nil
+}
+
+// listPagesBranchFiles maps the path of each file on the pages branch to its blob sha. A
+// repository without the branch has no files.
+func (giteaObjectStorageProviderManager *GiteaObjectStorageProviderManager) listPagesBranchFiles() (map[string]string, error) {
+	const pageSize = 1000
+	shas := map[string]string{}
+	for page := 1; ; page++ {
+		var tree giteaTree
+		query := url.Values{
+			"recursive": {"true"},
+			"page":      {strconv.Itoa(page)},
+			"per_page":  {strconv.Itoa(pageSize)},
+		}
+		statusCode, err := giteaObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodGet, giteaObjectStorageProviderManager.repositoryApiPath()+"/git/trees/"+giteaPagesBranchName+"?"+query.Encode(), nil, &tree)
+		if statusCode == http.StatusNotFound {
+			return shas, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files on the %s branch: %w", giteaPagesBranchName, err)
+		}
+		for _, entry := range tree.Entries {
+			if entry.Type == "blob" {
+				shas[entry.Path] = entry.Sha
+			}
+		}
+		if !tree.Truncated || len(tree.Entries) == 0 {
+			return shas, nil
+		}
+	}
+}
+
+func (giteaObjectStorageProviderManager *GiteaObjectStorageProviderManager) CreateAvailableDomain() error {
+	// The pages server picks up the custom domain from the committed .domains file
+	if giteaObjectStorageProviderManager.deploymentState != nil {
+		return nil
+	}
+	deploymentState := &DeploymentState{
+		Domain:     giteaObjectStorageProviderManager.domainName,
+		Backend:    "gitea",
+		Repository: giteaObjectStorageProviderManager.repository(),
+	}
+	return deploymentState.Save()
+}
+
+func (giteaObjectStorageProviderManager *GiteaObjectStorageProviderManager) GetRequiredDnsRecords() ([]*types.ResourceRecordSet, error) {
+	if giteaObjectStorageProviderManager.repositoryOwner == "" {
+		return nil, errors.New("repository owner not set; call InstantiateClient first")
+	}
+	// A CNAME to repo.owner.<pages domain> both routes and verifies the subdomain; the
+	// TXT form of verification is only needed for apex domains, which hostit does not host
+	pagesHost := strings.ToLower(giteaObjectStorageProviderManager.repositoryName + "." + giteaObjectStorageProviderManager.repositoryOwner + "." + giteaObjectStorageProviderManager.pagesDomain)
+	return []*types.ResourceRecordSet{
+		{
+			Name: aws.String(giteaObjectStorageProviderManager.domainName + "."),
+			Type: types.RRTypeCname,
+			TTL:  aws.Int64(300),
+			ResourceRecords: []types.ResourceRecord{
+				{
+					Value: aws.String(pagesHost),
+				},
+			},
+		},
+	}, nil
+}
+
+func (giteaObjectStorageProviderManager *GiteaObjectStorageProviderManager) FinalizeHttps() error {
+	return nil
+}
+
+func (giteaObjectStorageProviderManager *GiteaObjectStorageProviderManager) repository() string {
+	return giteaObjectStorageProviderManager.repositoryOwner + "/" + giteaObjectStorageProviderManager.repositoryName
+}
+
+func (giteaObjectStorageProviderManager *GiteaObjectStorageProviderManager) repositoryApiPath() string {
+	return "/repos/" + url.PathEscape(giteaObjectStorageProviderManager.repositoryOwner) + "/" + url.PathEscape(giteaObjectStorageProviderManager.repositoryName)
+}
+
+func NewGiteaObjectStorageProviderManager(domainName string, folderName string) (*GiteaObjectStorageProviderManager, error) {
+	if folderName == "" {
+		return nil, errors.New("folderName must not be empty")
+	}
+	// Dots in the repository name would be read as extra subdomain levels by the pages server
+	return &GiteaObjectStorageProviderManager{
+		domainName:      domainName,
+		folderName:      folderName,
+		repositoryName:  strings.ToLower(strings.ReplaceAll(domainName, ".", "-")),
+		repositoryOwner: "",
+		baseUrl:         defaultGiteaBaseUrl,
+		pagesDomain:     defaultGiteaPagesDomain,
+		apiClient:       nil,
+		deploymentState: nil,
+	}, nil
+}
+
+// gitBlobSha is the object id git gives a file with content data
+func gitBlobSha(data []byte) string {
+	hash := sha1.New()
+	fmt.Fprintf(hash, "blob %d\x00", len(data))
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
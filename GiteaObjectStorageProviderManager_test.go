@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// fakeGitea serves a repository whose pages branch holds branchFiles, and records the file
+// operations committed to it
+type fakeGitea struct {
+	branchFiles map[string]string
+	committed   []giteaFileOperation
+}
+
+func (fake *fakeGitea) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "token test-token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/user":
+		json.NewEncoder(w).Encode(giteaUser{Login: "alice"})
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/repos/alice/www-example-com":
+		w.Write([]byte(`{"name":"www-example-com"}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/repos/alice/www-example-com/git/trees/pages":
+		// Serve one entry per page to exercise the pagination
+		paths := []string{"README.md", "index.html", "old.html", "style.css"}
+		page := 1
+		if r.URL.Query().Get("page") != "" {
+			page = int(r.URL.Query().Get("page")[0] - '0')
+		}
+		tree := giteaTree{Truncated: page < len(paths), TotalCount: len(paths)}
+		if page <= len(paths) {
+			path := paths[page-1]
+			tree.Entries = []giteaTreeEntry{{Path: path, Type: "blob", Sha: gitBlobSha([]byte(fake.branchFiles[path]))}}
+		}
+		json.NewEncoder(w).Encode(tree)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/repos/alice/www-example-com/contents":
+		var body struct {
+			Branch string               `json:"branch"`
+			Files  []giteaFileOperation `json:"files"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Branch != giteaPagesBranchName {
+			http.Error(w, "bad commit", http.StatusUnprocessableEntity)
+			return
+		}
+		fake.committed = body.Files
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "unexpected request", http.StatusNotImplemented)
+	}
+}
+
+func TestGiteaAdoptAndUpdateRepository(t *testing.T) {
+	fake := &fakeGitea{branchFiles: map[string]string{
+		"README.md":  "# www-example-com",
+		"index.html": "unchanged",
+		"old.html":   "gone",
+		"style.css":  "body {}",
+	}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	t.Setenv("GITEA_TOKEN", "test-token")
+	t.Setenv("GITEA_URL", server.URL)
+	t.Setenv("HOSTIT_STATE_DIR", t.TempDir())
+
+	folderName := writeTestSite(t, map[string]string{
+		"index.html": "unchanged",
+		"about.html": "new",
+		"style.css":  "p {}",
+	})
+	manager, err := NewGiteaObjectStorageProviderManager("www.example.com", folderName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.InstantiateClient(); err != nil {
+		t.Fatal(err)
+	}
+	available, err := manager.VerifyNamespace()
+	if err != nil || available {
+		t.Fatalf("VerifyNamespace() = %v, %v; want the existing repository reported", available, err)
+	}
+	if err := manager.AdoptExistingDeployment(); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []func() error{manager.CreateStorageInstance, manager.UploadFilesToNewInstance, manager.CreateAvailableDomain} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []giteaFileOperation{
+		{Operation: "create", Path: ".domains", Content: "d3d3LmV4YW1wbGUuY29tCg=="},
+		{Operation: "delete", Path: "README.md", Sha: gitBlobSha([]byte("# www-example-com"))},
+		{Operation: "create", Path: "about.html", Content: "bmV3"},
+		{Operation: "delete", Path: "old.html", Sha: gitBlobSha([]byte("gone"))},
+		{Operation: "update", Path: "style.css", Content: "cCB7fQ==", Sha: gitBlobSha([]byte("body {}"))},
+	}
+	if !reflect.DeepEqual(fake.committed, want) {
+		t.Errorf("committed %+v; want %+v", fake.committed, want)
+	}
+
+	// The next deploy finds the repository through the saved state
+	deploymentState, err := LoadDeploymentState("www.example.com")
+	if err != nil || deploymentState == nil || deploymentState.Repository != "alice/www-example-com" {
+		t.Errorf("deployment state = %+v, %v", deploymentState, err)
+	}
+}
+
+func TestGitBlobSha(t *testing.T) {
+	// As printed by `printf 'hello\n' | git hash-object --stdin`
+	if sha := gitBlobSha([]byte("hello\n")); sha != "ce013625030ba8dba906f756967f9e9ca394464a" {
+		t.Errorf("gitBlobSha = %s", sha)
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"html"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -21,6 +23,7 @@ type GithubObjectStorageProviderManager struct {
 	repositoryName  string
 	folderName      string
 	githubClient    *github.Client
+	deploymentState *DeploymentState
 }
 
 func (githubObjectStorageProviderManager *GithubObjectStorageProviderManager) InstantiateClient() error {
@@ -42,10 +45,25 @@ func (githubObjectStorageProviderManager *GithubObjectStorageProviderManager) In
 		return fmt.Errorf("failed to determine authenticated user login: %w", err)
 	}
 	githubObjectStorageProviderManager.repositoryOwner = *user.Login
+
+	deploymentState, err := LoadDeploymentState(githubObjectStorageProviderManager.repositoryName)
+	if err != nil {
+		return err
+	}
+	if deploymentState != nil && deploymentState.Backend == "github" {
+		githubObjectStorageProviderManager.deploymentState = deploymentState
+		if owner, name, ok := strings.Cut(deploymentState.Repository, "/"); ok {
+			githubObjectStorageProviderManager.repositoryOwner, githubObjectStorageProviderManager.repositoryName = owner, name
+		}
+	}
 	return nil
 }
 
 func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) VerifyNamespace() (bool, error) {
+	// A repository hostit deployed before is updated in place
+	if githubObjectStorageProviderManager.deploymentState != nil {
+		return true, nil
+	}
 	_, resp, err := githubObjectStorageProviderManager.githubClient.Repositories.Get(context.Background(), githubObjectStorageProviderManager.repositoryOwner, githubObjectStorageProviderManager.repositoryName)
 	if err != nil {
 		if resp != nil && resp.StatusCode == 404 {
@@ -57,7 +75,64 @@ func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) Ver
 	return false, nil
 }
 
+// ExistingDeployment describes the existing repository. Deploys replace the whole tree, so unless
+// hostit released to the repository before, adopting it deletes everything in it.
+func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) ExistingDeployment() string {
+	hasReleases, err := githubObjectStorageProviderManager.hasReleaseCommits()
+	if err != nil {
+		fmt.Printf("Warning: could not read the history of %s: %s\n", githubObjectStorageProviderManager.repository(), err)
+	}
+	if hasReleases {
+		return fmt.Sprintf("Repository %s already exists and holds hostit releases", githubObjectStorageProviderManager.repository())
+	}
+	return fmt.Sprintf("Repository %s already exists but hostit never released to it; adopting it deletes all of its existing files", githubObjectStorageProviderManager.repository())
+}
+
+// hasReleaseCommits reports whether the main branch has a commit hostit created
+func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) hasReleaseCommits() (bool, error) {
+	listOptions := &github.CommitsListOptions{
+		SHA:         "main",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		commits, resp, err := githubObjectStorageProviderManager.githubClient.Repositories.ListCommits(context.Background(), githubObjectStorageProviderManager.repositoryOwner, githubObjectStorageProviderManager.repositoryName, listOptions)
+		if err != nil {
+			// An empty repository or one without a main branch has no releases
+			if resp != nil && (resp.StatusCode == 404 || resp.StatusCode == 409) {
+				return false, nil
+			}
+			return false, err
+		}
+		for _, commit := range commits {
+			if parseGithubReleaseCommitMessage(commit.GetCommit().GetMessage()) != nil {
+				return true, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return false, nil
+		}
+		listOptions.Page = resp.NextPage
+	}
+}
+
+// AdoptExistingDeployment records the existing repository, so this and later deploys commit to it
+func (githubObjectStorageProviderManager *GithubObjectStorageProviderManager) AdoptExistingDeployment() error {
+	deploymentState := &DeploymentState{
+		Domain:     githubObjectStorageProviderManager.repositoryName,
+		Backend:    "github",
+		Repository: githubObjectStorageProviderManager.repository(),
+	}
+	if err := deploymentState.Save(); err != nil {
+		return err
+	}
+	githubObjectStorageProviderManager.deploymentState = deploymentState
+	return nil
+}
+
 func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) CreateStorageInstance() error {
+	if githubObjectStorageProviderManager.deploymentState != nil {
+		return nil
+	}
 	repoIsPrivate := false
 	autoInit := true
 	repoDescription := "Hosted through hostit"
@@ -106,6 +181,14 @@ func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) Upl
 	if err != nil {
 		return err
 	}
+	// GitHub Pages has no redirects, so rules are published as meta refresh pages instead
+	redirectRules, err := LoadRedirectRules(githubObjectStorageProviderManager.folderName)
+	if err != nil {
+		return err
+	}
+	filesToUpload = slices.DeleteFunc(filesToUpload, func(repoPath string) bool {
+		return IsRedirectRulesFile(repoPath) || IsHeaderRulesFile(repoPath)
+	})
 	for _, repoPath := range filesToUpload {
 		fullPath := filepath.Join(githubObjectStorageProviderManager.folderName, filepath.FromSlash(repoPath))
 		data, err := os.ReadFile(fullPath)
@@ -152,6 +235,36 @@ func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) Upl
 		return fmt.Errorf("completed with %d errors", len(uploadErrs))
 	}
 
+	for _, rule := range redirectRules {
+		if rule.IsSplat() {
+			fmt.Printf("Skipping redirect '%s': GitHub Pages cannot redirect a splat\n", rule.From)
+			continue
+		}
+		stubPath := redirectStubPath(rule.From)
+		if slices.Contains(filesToUpload, stubPath) {
+			fmt.Printf("Skipping redirect '%s': '%s' exists in the upload folder\n", rule.From, stubPath)
+			continue
+		}
+		content := base64.StdEncoding.EncodeToString(redirectStubPage(rule.To))
+		encoding := "base64"
+		blob, _, err := client.Git.CreateBlob(ctx, githubObjectStorageProviderManager.repositoryOwner, githubObjectStorageProviderManager.repositoryName, &github.Blob{
+			Content:  &content,
+			Encoding: &encoding,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create blob for redirect '%s': %w", rule.From, err)
+		}
+		mode := "100644"
+		typeBlob := "blob"
+		treeEntries = append(treeEntries, &github.TreeEntry{
+			Path: &stubPath,
+			Mode: &mode,
+			Type: &typeBlob,
+			SHA:  blob.SHA,
+		})
+		log.Printf("Redirecting '%s' to '%s' (status %d served as a meta refresh)", rule.From, rule.To, rule.Status)
+	}
+
 	// Ensure a CNAME file exists at the repository root so GitHub Pages sets the custom domain
 	if !hasCNAMEAtRoot {
 		cnameContent := []byte(githubObjectStorageProviderManager.repositoryName)
@@ -176,8 +289,8 @@ func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) Upl
 		})
 	}
 
-	// Determine base tree and parents (if branch exists)
-	var baseTreeSHA string
+	// The tree lists every file of the site rather than building on the tree of the parent
+	// commit, so files removed from the upload folder are removed from the site too
 	var parents []*github.Commit
 
 	ref, resp, err := client.Git.GetRef(ctx, githubObjectStorageProviderManager.repositoryOwner, githubObjectStorageProviderManager.repositoryName, "heads/"+branchName)
@@ -197,18 +310,19 @@ func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) Upl
 		if err != nil {
 			return fmt.Errorf("failed to get parent commit: %w", err)
 		}
-		if parentCommit.Tree != nil && parentCommit.Tree.SHA != nil {
-			baseTreeSHA = *parentCommit.Tree.SHA
-		}
 		parents = []*github.Commit{parentCommit}
 	}
 
-	tree, _, err := client.Git.CreateTree(ctx, githubObjectStorageProviderManager.repositoryOwner, githubObjectStorageProviderManager.repositoryName, baseTreeSHA, treeEntries)
+	tree, _, err := client.Git.CreateTree(ctx, githubObjectStorageProviderManager.repositoryOwner, githubObjectStorageProviderManager.repositoryName, "", treeEntries)
 	if err != nil {
 		return fmt.Errorf("failed to create tree: %w", err)
 	}
 
-	commitMessage := "Github pages content"
+	commitMessage := githubReleaseCommitMessage(&ReleaseManifest{
+		Id:     NewReleaseId(),
+		Files:  len(filesToUpload),
+		Source: describeSource(githubObjectStorageProviderManager.folderName),
+	})
 	commitInput := &github.Commit{
 		Message: &commitMessage,
 		Tree:    tree,
@@ -255,13 +369,22 @@ func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) Cre
 			Path:   &path,
 		},
 	}
-	_, _, err := githubObjectStorageProviderManager.githubClient.Repositories.EnablePages(
+	_, resp, err := githubObjectStorageProviderManager.githubClient.Repositories.EnablePages(
 		context.Background(), githubObjectStorageProviderManager.repositoryOwner, githubObjectStorageProviderManager.repositoryName, pages,
 	)
-	if err != nil {
+	// 409 means Pages is already enabled, as it is when a deploy updates the repository
+	if err != nil && (resp == nil || resp.StatusCode != 409) {
 		return fmt.Errorf("failed to enable Pages: %w", err)
 	}
-	return nil
+	if githubObjectStorageProviderManager.deploymentState != nil {
+		return nil
+	}
+	deploymentState := &DeploymentState{
+		Domain:     githubObjectStorageProviderManager.repositoryName,
+		Backend:    "github",
+		Repository: githubObjectStorageProviderManager.repository(),
+	}
+	return deploymentState.Save()
 }
 
 func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) GetRequiredDnsRecords() ([]*types.ResourceRecordSet, error) {
@@ -283,6 +406,41 @@ func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) Fin
 	return nil
 }
 
+func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) repository() string {
+	return githubObjectStorageProviderManager.repositoryOwner + "/" + githubObjectStorageProviderManager.repositoryName
+}
+
+// redirectStubPath is the file GitHub Pages serves for from: the page itself when it names an
+// .html file, otherwise the index of the directory
+func redirectStubPath(from string) string {
+	trimmed := strings.Trim(from, "/")
+	if strings.HasSuffix(trimmed, ".html") {
+		return trimmed
+	}
+	if trimmed == "" {
+		return "index.html"
+	}
+	return trimmed + "/index.html"
+}
+
+func redirectStubPage(to string) []byte {
+	target := html.EscapeString(to)
+	return []byte(fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Redirecting</title>
+<link rel="canonical" href="%[1]s">
+<meta http-equiv="refresh" content="0; url=%[1]s">
+<meta name="robots" content="noindex">
+</head>
+<body>
+<a href="%[1]s">%[1]s</a>
+</body>
+</html>
+`, target))
+}
+
 func NewGithubObjectStorageProviderManager(repositoryName string, folderName string) (*GithubObjectStorageProviderManager, error) {
 	if folderName == "" {
 		return nil, errors.New("folderName must not be empty")
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v74/github"
+)
+
+func newGithubTestManager(t *testing.T, commitMessages []string) *GithubObjectStorageProviderManager {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/repos/alice/www.example.com/commits" {
+			http.Error(w, "unexpected request", http.StatusNotImplemented)
+			return
+		}
+		if commitMessages == nil {
+			http.Error(w, `{"message":"Git Repository is empty."}`, http.StatusConflict)
+			return
+		}
+		commits := make([]*github.RepositoryCommit, 0, len(commitMessages))
+		for _, message := range commitMessages {
+			commits = append(commits, &github.RepositoryCommit{Commit: &github.Commit{Message: github.Ptr(message)}})
+		}
+		json.NewEncoder(w).Encode(commits)
+	}))
+	t.Cleanup(server.Close)
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return &GithubObjectStorageProviderManager{
+		repositoryOwner: "alice",
+		repositoryName:  "www.example.com",
+		githubClient:    client,
+	}
+}
+
+func TestGithubExistingDeployment(t *testing.T) {
+	release := githubReleaseCommitMessage(&ReleaseManifest{Id: "20261019T120000.000000000Z", Files: 3})
+	tests := []struct {
+		name           string
+		commitMessages []string
+		wantWarning    bool
+	}{
+		{"hostit releases", []string{"Update README", release}, false},
+		{"unrelated history", []string{"Initial commit"}, true},
+		{"empty repository", nil, true},
+	}
+	for _, test := range tests {
+		message := newGithubTestManager(t, test.commitMessages).ExistingDeployment()
+		if warns := strings.Contains(message, "deletes all of its existing files"); warns != test.wantWarning {
+			t.Errorf("%s: ExistingDeployment() = %q", test.name, message)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v74/github"
+)
+
+// Trailers hostit adds to the commits it creates on GitHub, so releases can be read back from
+// the branch history
+const (
+	releaseIdTrailer    = "Hostit-Release"
+	releaseFilesTrailer = "Files"
+	sourceTrailer       = "Source"
+	restoredFromTrailer = "Restored-From"
+)
+
+const (
+	pagesBuildPollInterval = 5 * time.Second
+	pagesBuildTimeout      = 10 * time.Minute
+)
+
+func githubReleaseCommitMessage(release *ReleaseManifest) string {
+	var message strings.Builder
+	if release.RestoredFrom != "" {
+		fmt.Fprintf(&message, "hostit rollback to %s\n\n", release.RestoredFrom)
+	} else {
+		fmt.Fprintf(&message, "hostit release %s\n\n", release.Id)
+	}
+	fmt.Fprintf(&message, "%s: %s\n", releaseIdTrailer, release.Id)
+	fmt.Fprintf(&message, "%s: %d\n", releaseFilesTrailer, release.Files)
+	if release.Source != "" {
+		fmt.Fprintf(&message, "%s: %s\n", sourceTrailer, release.Source)
+	}
+	if release.RestoredFrom != "" {
+		fmt.Fprintf(&message, "%s: %s\n", restoredFromTrailer, release.RestoredFrom)
+	}
+	return message.String()
+}
+
+// parseGithubReleaseCommitMessage reads the trailers of a commit message, returning nil for
+// commits hostit did not create
+func parseGithubReleaseCommitMessage(message string) *ReleaseManifest {
+	release := &ReleaseManifest{Backend: "github"}
+	scanner := bufio.NewScanner(strings.NewReader(message))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ": ")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case releaseIdTrailer:
+			release.Id = value
+		case releaseFilesTrailer:
+			release.Files, _ = strconv.Atoi(value)
+		case sourceTrailer:
+			release.Source = value
+		case restoredFromTrailer:
+			release.RestoredFrom = value
+		}
+	}
+	if release.Id == "" {
+		return nil
+	}
+	return release
+}
+
+// describeSource names the folder a release was deployed from, with its git commit when the
+// folder is inside a repository
+func describeSource(folderName string) string {
+	source, err := filepath.Abs(folderName)
+	if err != nil {
+		source = folderName
+	}
+	revision, err := exec.Command("git", "-C", folderName, "rev-parse", "--short", "HEAD").Output()
+	if err != nil {
+		return source
+	}
+	source += " @ " + strings.TrimSpace(string(revision))
+	status, err := exec.Command("git", "-C", folderName, "status", "--porcelain", "--", ".").Output()
+	if err == nil && len(bytes.TrimSpace(status)) > 0 {
+		source += "+dirty"
+	}
+	return source
+}
+
+// ListReleases reads the releases hostit committed to the main branch, oldest first
+func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) ListReleases() ([]*ReleaseManifest, error) {
+	client := githubObjectStorageProviderManager.githubClient
+	if client == nil {
+		return nil, errors.New("client not instantiated")
+	}
+	ctx := context.Background()
+	listOptions := &github.CommitsListOptions{
+		SHA:         "main",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var releases []*ReleaseManifest
+	for {
+		commits, resp, err := client.Repositories.ListCommits(ctx, githubObjectStorageProviderManager.repositoryOwner, githubObjectStorageProviderManager.repositoryName, listOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list commits: %w", err)
+		}
+		for _, commit := range commits {
+			release := parseGithubReleaseCommitMessage(commit.GetCommit().GetMessage())
+			if release == nil {
+				continue
+			}
+			release.Domain = githubObjectStorageProviderManager.repositoryName
+			release.Commit = commit.GetSHA()
+			release.CreatedAt = commit.GetCommit().GetCommitter().GetDate().Time
+			releases = append(releases, release)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		listOptions.Page = resp.NextPage
+	}
+	// Commits are listed newest first
+	for left, right := 0, len(releases)-1; left < right; left, right = left+1, right-1 {
+		releases[left], releases[right] = releases[right], releases[left]
+	}
+	return releases, nil
+}
+
+// RestoreRelease commits the tree of release on top of main, so history is kept and the
+// rollback itself shows up as a release. It returns the new commit.
+func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) RestoreRelease(release *ReleaseManifest) (string, error) {
+	client := githubObjectStorageProviderManager.githubClient
+	if client == nil {
+		return "", errors.New("client not instantiated")
+	}
+	if release.Commit == "" {
+		return "", fmt.Errorf("release %s has no commit", release.Id)
+	}
+	ctx := context.Background()
+	owner, name := githubObjectStorageProviderManager.repositoryOwner, githubObjectStorageProviderManager.repositoryName
+
+	ref, _, err := client.Git.GetRef(ctx, owner, name, "heads/main")
+	if err != nil {
+		return "", fmt.Errorf("failed to get ref for branch 'main': %w", err)
+	}
+	releaseCommit, _, err := client.Git.GetCommit(ctx, owner, name, release.Commit)
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit of release %s: %w", release.Id, err)
+	}
+
+	commitMessage := githubReleaseCommitMessage(&ReleaseManifest{
+		Id:           NewReleaseId(),
+		RestoredFrom: release.Id,
+		Files:        release.Files,
+		Source:       release.Source,
+	})
+	newCommit, _, err := client.Git.CreateCommit(ctx, owner, name, &github.Commit{
+		Message: &commitMessage,
+		Tree:    releaseCommit.Tree,
+		Parents: []*github.Commit{{SHA: ref.Object.SHA}},
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create commit: %w", err)
+	}
+	ref.Object.SHA = newCommit.SHA
+	if _, _, err := client.Git.UpdateRef(ctx, owner, name, ref, false); err != nil {
+		return "", fmt.Errorf("failed to update ref for branch 'main': %w", err)
+	}
+	fmt.Printf("Restored release %s as commit %s\n", release.Id, newCommit.GetSHA())
+	return newCommit.GetSHA(), nil
+}
+
+// WaitForPagesBuild waits until GitHub Pages has built and published commitSha
+func (githubObjectStorageProviderManager GithubObjectStorageProviderManager) WaitForPagesBuild(commitSha string) error {
+	client := githubObjectStorageProviderManager.githubClient
+	if client == nil {
+		return errors.New("client not instantiated")
+	}
+	ctx := context.Background()
+	deadline := time.Now().Add(pagesBuildTimeout)
+	fmt.Println("Waiting for the GitHub Pages build...")
+	for {
+		build, resp, err := client.Repositories.GetLatestPagesBuild(ctx, githubObjectStorageProviderManager.repositoryOwner, githubObjectStorageProviderManager.repositoryName)
+		// Until the first build for the branch is queued there is no latest build
+		if err != nil && (resp == nil || resp.StatusCode != 404) {
+			return fmt.Errorf("failed to get Pages build: %w", err)
+		}
+		if err == nil && build.GetCommit() == commitSha {
+			switch build.GetStatus() {
+			case "built":
+				fmt.Println("GitHub Pages build finished")
+				return nil
+			case "errored":
+				return fmt.Errorf("GitHub Pages build failed: %s", build.GetError().GetMessage())
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("GitHub Pages build of %s did not finish within %s", commitSha, pagesBuildTimeout)
+		}
+		time.Sleep(pagesBuildPollInterval)
+	}
+}
+
+// openGithubDeployment connects to the repository recorded for a GitHub Pages deploy
+func openGithubDeployment(deploymentState *DeploymentState) (*GithubObjectStorageProviderManager, error) {
+	githubObjectStorageProviderManager := &GithubObjectStorageProviderManager{
+		repositoryName: deploymentState.Domain,
+	}
+	if err := githubObjectStorageProviderManager.InstantiateClient(); err != nil {
+		return nil, err
+	}
+	return githubObjectStorageProviderManager, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// headerRulesFileName uses the Netlify syntax: an unindented path followed by indented
+// "Name: value" lines
+const headerRulesFileName = "_headers"
+
+// HeaderField is one response header set by a HeaderRule
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// HeaderRule adds Headers to responses for Path. A Path ending in /* matches everything below
+// it, and /* on its own matches every path.
+type HeaderRule struct {
+	Path    string
+	Headers []HeaderField
+}
+
+func (rule HeaderRule) IsGlobal() bool {
+	return rule.Path == "/*"
+}
+
+// CloudFrontPathPatterns lists the cache behavior path patterns covering the rule. CloudFront
+// picks the behavior before pretty URLs rewrite the request, so a page is matched both as /page
+// and as /page/.
+func (rule HeaderRule) CloudFrontPathPatterns() []string {
+	if rule.Path == "/" || strings.HasSuffix(rule.Path, "/*") {
+		return []string{rule.Path}
+	}
+	page := strings.TrimSuffix(rule.Path, "/")
+	if strings.Contains(path.Base(page), ".") {
+		return []string{rule.Path}
+	}
+	return []string{page, page + "/"}
+}
+
+func IsHeaderRulesFile(repoPath string) bool {
+	return repoPath == headerRulesFileName
+}
+
+// LoadHeaderRules reads the _headers file at the root of folderName, returning nil if there
+// is none
+func LoadHeaderRules(folderName string) ([]HeaderRule, error) {
+	f, err := os.Open(filepath.Join(folderName, headerRulesFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open '%s': %w", headerRulesFileName, err)
+	}
+	defer f.Close()
+	rules, err := ParseHeaderRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header rules in '%s': %w", headerRulesFileName, err)
+	}
+	return rules, nil
+}
+
+// ParseHeaderRules reads path blocks and their indented headers. Blank lines and # comments
+// are skipped; a path listed twice gets the headers of both blocks.
+func ParseHeaderRules(reader io.Reader) ([]HeaderRule, error) {
+	var rules []HeaderRule
+	rulesByPath := map[string]int{}
+	current := -1
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		rawLine := scanner.Text()
+		line := strings.TrimSpace(rawLine)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rawLine[0] != ' ' && rawLine[0] != '\t' {
+			if !strings.HasPrefix(line, "/") {
+				return nil, fmt.Errorf("line %d: path '%s' must start with /", lineNumber, line)
+			}
+			if strings.Contains(line, "/:") || strings.Contains(strings.TrimSuffix(line, "/*"), "*") {
+				return nil, fmt.Errorf("line %d: '%s' may only use a trailing /* as a wildcard", lineNumber, line)
+			}
+			index, ok := rulesByPath[line]
+			if !ok {
+				index = len(rules)
+				rulesByPath[line] = index
+				rules = append(rules, HeaderRule{Path: line})
+			}
+			current = index
+			continue
+		}
+		if current < 0 {
+			return nil, fmt.Errorf("line %d: header '%s' comes before any path", lineNumber, line)
+		}
+		name, value, found := strings.Cut(line, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !found || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("line %d: expected 'Name: value', got '%s'", lineNumber, line)
+		}
+		rules[current].Headers = append(rules[current].Headers, HeaderField{Name: name, Value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// WarnUnsupportedHeaderRules tells the user that backendName will not send the headers in the
+// upload folder's _headers file
+func WarnUnsupportedHeaderRules(folderName string, backendName string) error {
+	rules, err := LoadHeaderRules(folderName)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		for _, header := range rule.Headers {
+			fmt.Printf("Warning: %s cannot set response headers; '%s' will not be sent for %s\n", backendName, header.Name, rule.Path)
+		}
+	}
+	return nil
+}
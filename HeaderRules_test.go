@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHeaderRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []HeaderRule
+		wantErr string
+	}{
+		{
+			name:  "paths with headers",
+			input: "# fonts\n/fonts/*\n  Access-Control-Allow-Origin: *\n\n/embed\n\tContent-Security-Policy: frame-ancestors *\n",
+			want: []HeaderRule{
+				{Path: "/fonts/*", Headers: []HeaderField{{"Access-Control-Allow-Origin", "*"}}},
+				{Path: "/embed", Headers: []HeaderField{{"Content-Security-Policy", "frame-ancestors *"}}},
+			},
+		},
+		{
+			name:  "a path listed twice merges its headers",
+			input: "/*\n  X-One: 1\n/a\n  X-Two: 2\n/*\n  X-Three: 3\n",
+			want: []HeaderRule{
+				{Path: "/*", Headers: []HeaderField{{"X-One", "1"}, {"X-Three", "3"}}},
+				{Path: "/a", Headers: []HeaderField{{"X-Two", "2"}}},
+			},
+		},
+		{
+			name:  "values keep their colons",
+			input: "/\n  Link: <https://example.com/style.css>; rel=preload\n",
+			want:  []HeaderRule{{Path: "/", Headers: []HeaderField{{"Link", "<https://example.com/style.css>; rel=preload"}}}},
+		},
+		{name: "relative path", input: "fonts/*\n  X-A: 1\n", wantErr: "line 1: path 'fonts/*' must start with /"},
+		{name: "inner wildcard", input: "/*/fonts\n  X-A: 1\n", wantErr: "only use a trailing /*"},
+		{name: "partial wildcard", input: "/fonts*\n  X-A: 1\n", wantErr: "only use a trailing /*"},
+		{name: "placeholder", input: "/blog/:slug\n  X-A: 1\n", wantErr: "only use a trailing /*"},
+		{name: "header before any path", input: "  X-A: 1\n", wantErr: "line 1: header 'X-A: 1' comes before any path"},
+		{name: "header without a colon", input: "/\n  X-A 1\n", wantErr: "line 2: expected 'Name: value'"},
+		{name: "header name with a space", input: "/\n  X A: 1\n", wantErr: "line 2: expected 'Name: value'"},
+	}
+	for _, test := range tests {
+		rules, err := ParseHeaderRules(strings.NewReader(test.input))
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error = %v; want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(rules, test.want) {
+			t.Errorf("%s: rules = %+v; want %+v", test.name, rules, test.want)
+		}
+	}
+}
+
+func TestHeaderRuleCloudFrontPathPatterns(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/embed", []string{"/embed", "/embed/"}},
+		{"/embed/", []string{"/embed", "/embed/"}},
+		{"/docs/guide", []string{"/docs/guide", "/docs/guide/"}},
+		{"/embed.html", []string{"/embed.html"}},
+		{"/v1.2/page", []string{"/v1.2/page", "/v1.2/page/"}},
+		{"/fonts/*", []string{"/fonts/*"}},
+		{"/", []string{"/"}},
+	}
+	for _, test := range tests {
+		if got := (HeaderRule{Path: test.path}).CloudFrontPathPatterns(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("CloudFrontPathPatterns(%s) = %v; want %v", test.path, got, test.want)
+		}
+	}
+}
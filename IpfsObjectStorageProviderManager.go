@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+const defaultIpfsApiUrl = "http://127.0.0.1:5001"
+
+// IpfsObjectStorageProviderManager adds the site to IPFS through a Kubo node's RPC API and
+// publishes it with a DNSLink record, so each redeploy only has to update that record.
+type IpfsObjectStorageProviderManager struct {
+	domainName  string
+	folderName  string
+	apiUrl      string
+	gatewayHost string
+	httpClient  *http.Client
+	rootCid     string
+	// previousRootCid is the pinned root the DNSLink record pointed at before this deploy
+	previousRootCid string
+}
+
+type ipfsAddResult struct {
+	Name string `json:"Name"`
+	Hash string `json:"Hash"`
+}
+
+func (ipfsObjectStorageProviderManager *IpfsObjectStorageProviderManager) InstantiateClient() error {
+	if apiUrl := os.Getenv("IPFS_API_URL"); apiUrl != "" {
+		ipfsObjectStorageProviderManager.apiUrl = strings.TrimSuffix(apiUrl, "/")
+	}
+	// A gateway that serves DNSLink content lets browsers reach the site without an IPFS client
+	ipfsObjectStorageProviderManager.gatewayHost = os.Getenv("HOSTIT_IPFS_GATEWAY")
+	ipfsObjectStorageProviderManager.httpClient = &http.Client{}
+
+	var identity struct {
+		ID string `json:"ID"`
+	}
+	resp, err := ipfsObjectStorageProviderManager.post("/api/v0/id", nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to reach ipfs node at %s: %w", ipfsObjectStorageProviderManager.apiUrl, err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&identity); err != nil {
+		return fmt.Errorf("failed to decode ipfs node identity: %w", err)
+	}
+	fmt.Printf("Using IPFS node %s for object storage provider\n", identity.ID)
+	return nil
+}
+
+func (ipfsObjectStorageProviderManager *IpfsObjectStorageProviderManager) VerifyNamespace() (bool, error) {
+	// Content is addressed by hash, so there is no name to collide with
+	return true, nil
+}
+
+func (ipfsObjectStorageProviderManager *IpfsObjectStorageProviderManager) CreateStorageInstance() error {
+	return nil
+}
+
+func (ipfsObjectStorageProviderManager *IpfsObjectStorageProviderManager) UploadFilesToNewInstance() error {
+	const maxFileSizeBytes int64 = 1 * 1024 * 1024 * 1024 // 1GB
+	const rootName = "site"
+
+	if ipfsObjectStorageProviderManager.httpClient == nil {
+		return errors.New("ipfs client not instantiated")
+	}
+
+	finder := NewUploadFileFinder()
+	filesToUpload, err := finder.FindFiles(ipfsObjectStorageProviderManager.folderName, maxFileSizeBytes)
+	if err != nil {
+		return err
+	}
+	if len(filesToUpload) == 0 {
+		return errors.New("no files to upload")
+	}
+
+	// Stream the tree as a multipart body; directories must be announced before their children
+	bodyReader, bodyWriter := io.Pipe()
+	multipartWriter := multipart.NewWriter(bodyWriter)
+	go func() {
+		writeErr := ipfsObjectStorageProviderManager.writeMultipartTree(multipartWriter, rootName, filesToUpload)
+		if writeErr == nil {
+			writeErr = multipartWriter.Close()
+		}
+		bodyWriter.CloseWithError(writeErr)
+	}()
+
+	query := url.Values{
+		"pin":         {"false"},
+		"cid-version": {"1"},
+		"progress":    {"false"},
+	}
+	resp, err := ipfsObjectStorageProviderManager.post("/api/v0/add", query, bodyReader, multipartWriter.FormDataContentType())
+	if err != nil {
+		return fmt.Errorf("failed to add files to ipfs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var result ipfsAddResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return fmt.Errorf("failed to decode ipfs add response: %w", err)
+		}
+		if result.Name == rootName {
+			ipfsObjectStorageProviderManager.rootCid = result.Hash
+		} else if result.Hash != "" {
+			log.Printf("Added '%s'", strings.TrimPrefix(result.Name, rootName+"/"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read ipfs add response: %w", err)
+	}
+	if ipfsObjectStorageProviderManager.rootCid == "" {
+		return errors.New("ipfs did not return a root cid")
+	}
+	fmt.Printf("Site added to IPFS as %s\n", ipfsObjectStorageProviderManager.rootCid)
+	return nil
+}
+
+func (ipfsObjectStorageProviderManager *IpfsObjectStorageProviderManager) CreateAvailableDomain() error {
+	if ipfsObjectStorageProviderManager.rootCid == "" {
+		return errors.New("site not added to ipfs")
+	}
+	resp, err := ipfsObjectStorageProviderManager.post("/api/v0/pin/add", url.Values{
+		"arg":       {ipfsObjectStorageProviderManager.rootCid},
+		"recursive": {"true"},
+	}, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to pin %s: %w", ipfsObjectStorageProviderManager.rootCid, err)
+	}
+	resp.Body.Close()
+
+	deploymentState, err := LoadDeploymentState(ipfsObjectStorageProviderManager.domainName)
+	if err != nil {
+		return err
+	}
+	if deploymentState != nil && deploymentState.Backend == "ipfs" && deploymentState.RootCid != ipfsObjectStorageProviderManager.rootCid {
+		ipfsObjectStorageProviderManager.previousRootCid = deploymentState.RootCid
+	}
+	return nil
+}
+
+// PruneSupersededContent unpins the previous root once the DNSLink record points at the new one,
+// so the node can garbage collect it, and records the new root for the next deploy
+func (ipfsObjectStorageProviderManager *IpfsObjectStorageProviderManager) PruneSupersededContent() error {
+	if previousRootCid := ipfsObjectStorageProviderManager.previousRootCid; previousRootCid != "" {
+		resp, err := ipfsObjectStorageProviderManager.post("/api/v0/pin/rm", url.Values{
+			"arg":       {previousRootCid},
+			"recursive": {"true"},
+		}, nil, "")
+		if err != nil {
+			// Someone may have unpinned it by hand; the new root is live either way
+			fmt.Printf("Warning: failed to unpin previous root %s: %s\n", previousRootCid, err)
+		} else {
+			resp.Body.Close()
+			fmt.Printf("Unpinned previous root %s\n", previousRootCid)
+		}
+	}
+	deploymentState := &DeploymentState{
+		Domain:  ipfsObjectStorageProviderManager.domainName,
+		Backend: "ipfs",
+		RootCid: ipfsObjectStorageProviderManager.rootCid,
+	}
+	return deploymentState.Save()
+}
+
+func (ipfsObjectStorageProviderManager *IpfsObjectStorageProviderManager) GetRequiredDnsRecords() ([]*types.ResourceRecordSet, error) {
+	if ipfsObjectStorageProviderManager.rootCid == "" {
+		return nil, errors.New("site not added to ipfs")
+	}
+	records := []*types.ResourceRecordSet{
+		{
+			Name: aws.String("_dnslink." + ipfsObjectStorageProviderManager.domainName + "."),
+			Type: types.RRTypeTxt,
+			TTL:  aws.Int64(60),
+			ResourceRecords: []types.ResourceRecord{
+				{
+					Value: aws.String(strconv.Quote("dnslink=/ipfs/" + ipfsObjectStorageProviderManager.rootCid)),
+				},
+			},
+		},
+	}
+	if ipfsObjectStorageProviderManager.gatewayHost != "" {
+		records = append(records, &types.ResourceRecordSet{
+			Name: aws.String(ipfsObjectStorageProviderManager.domainName + "."),
+			Type: types.RRTypeCname,
+			TTL:  aws.Int64(300),
+			ResourceRecords: []types.ResourceRecord{
+				{
+					Value: aws.String(ipfsObjectStorageProviderManager.gatewayHost),
+				},
+			},
+		})
+	}
+	return records, nil
+}
+
+func (ipfsObjectStorageProviderManager *IpfsObjectStorageProviderManager) FinalizeHttps() error {
+	return nil
+}
+
+func (ipfsObjectStorageProviderManager *IpfsObjectStorageProviderManager) writeMultipartTree(multipartWriter *multipart.Writer, rootName string, filesToUpload []string) error {
+	announcedDirs := NewSet[string]()
+	announceDir := func(dirPath string) error {
+		if announcedDirs.Contains(dirPath) {
+			return nil
+		}
+		announcedDirs.Add(dirPath)
+		_, err := multipartWriter.CreatePart(ipfsPartHeader(dirPath, "application/x-directory"))
+		return err
+	}
+	if err := announceDir(rootName); err != nil {
+		return err
+	}
+	for _, repoPath := range filesToUpload {
+		segments := strings.Split(repoPath, "/")
+		for index := 1; index < len(segments); index++ {
+			if err := announceDir(path.Join(rootName, strings.Join(segments[:index], "/"))); err != nil {
+				return err
+			}
+		}
+		part, err := multipartWriter.CreatePart(ipfsPartHeader(path.Join(rootName, repoPath), "application/octet-stream"))
+		if err != nil {
+			return err
+		}
+		fullPath := filepath.Join(ipfsObjectStorageProviderManager.folderName, filepath.FromSlash(repoPath))
+		f, err := os.Open(fullPath)
+		if err != nil {
+			return fmt.Errorf("failed to open '%s': %w", fullPath, err)
+		}
+		_, copyErr := io.Copy(part, f)
+		f.Close()
+		if copyErr != nil {
+			return fmt.Errorf("failed to read '%s': %w", fullPath, copyErr)
+		}
+	}
+	return nil
+}
+
+// post calls a Kubo RPC endpoint; every RPC method is a POST regardless of whether it has a body.
+func (ipfsObjectStorageProviderManager *IpfsObjectStorageProviderManager) post(endpoint string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	requestUrl := ipfsObjectStorageProviderManager.apiUrl + endpoint
+	if len(query) > 0 {
+		requestUrl += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodPost, requestUrl, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := ipfsObjectStorageProviderManager.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("ipfs returned %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return resp, nil
+}
+
+func ipfsPartHeader(partPath string, contentType string) textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, url.QueryEscape(partPath)))
+	header.Set("Content-Type", contentType)
+	return header
+}
+
+func NewIpfsObjectStorageProviderManager(domainName string, folderName string) (*IpfsObjectStorageProviderManager, error) {
+	if folderName == "" {
+		return nil, errors.New("folderName must not be empty")
+	}
+	return &IpfsObjectStorageProviderManager{
+		domainName:      domainName,
+		folderName:      folderName,
+		apiUrl:          defaultIpfsApiUrl,
+		gatewayHost:     "",
+		httpClient:      nil,
+		rootCid:         "",
+		previousRootCid: "",
+	}, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeKubo answers the RPC calls hostit makes to a Kubo node
+type fakeKubo struct {
+	mutex sync.Mutex
+	// parts holds the path and content type of each part of the last add, in order
+	parts    []string
+	contents map[string]string
+	addQuery url.Values
+	pinned   map[string]bool
+	adds     int
+}
+
+func (fake *fakeKubo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if r.Method != http.MethodPost {
+		http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	switch r.URL.Path {
+	case "/api/v0/id":
+		json.NewEncoder(w).Encode(map[string]string{"ID": "12D3KooWTest"})
+	case "/api/v0/add":
+		fake.adds++
+		fake.addQuery = query
+		fake.parts = nil
+		fake.contents = map[string]string{}
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		encoder := json.NewEncoder(w)
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// Part.FileName drops the directories, so read the full path from the header
+			_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			partPath, err := url.QueryUnescape(params["filename"])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			fake.parts = append(fake.parts, partPath+" "+part.Header.Get("Content-Type"))
+			data, _ := io.ReadAll(part)
+			fake.contents[partPath] = string(data)
+		}
+		// Kubo reports files first and the directories they are in last, the root at the very end
+		for index := len(fake.parts) - 1; index >= 0; index-- {
+			partPath, _, _ := strings.Cut(fake.parts[index], " ")
+			encoder.Encode(ipfsAddResult{Name: partPath, Hash: fakeCid(fake.adds, partPath)})
+		}
+	case "/api/v0/pin/add":
+		fake.pinned[query.Get("arg")] = true
+		json.NewEncoder(w).Encode(map[string][]string{"Pins": {query.Get("arg")}})
+	case "/api/v0/pin/rm":
+		if !fake.pinned[query.Get("arg")] {
+			http.Error(w, `{"Message":"not pinned or pinned indirectly","Code":0,"Type":"error"}`, http.StatusInternalServerError)
+			return
+		}
+		delete(fake.pinned, query.Get("arg"))
+		json.NewEncoder(w).Encode(map[string][]string{"Pins": {query.Get("arg")}})
+	default:
+		http.Error(w, "404 page not found", http.StatusNotFound)
+	}
+}
+
+func fakeCid(add int, partPath string) string {
+	return "bafy" + strings.Repeat("a", add) + strings.ReplaceAll(partPath, "/", "-")
+}
+
+func deployToFakeKubo(t *testing.T, folderName string) *IpfsObjectStorageProviderManager {
+	t.Helper()
+	manager, err := NewIpfsObjectStorageProviderManager("www.example.com", folderName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []func() error{
+		manager.InstantiateClient,
+		manager.CreateStorageInstance,
+		manager.UploadFilesToNewInstance,
+		manager.CreateAvailableDomain,
+		manager.PruneSupersededContent,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return manager
+}
+
+func TestIpfsDeploy(t *testing.T) {
+	fake := &fakeKubo{pinned: map[string]bool{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	t.Setenv("IPFS_API_URL", server.URL+"/")
+	t.Setenv("HOSTIT_IPFS_GATEWAY", "")
+	t.Setenv("HOSTIT_STATE_DIR", t.TempDir())
+
+	folderName := writeTestSite(t, map[string]string{
+		"index.html":          "<h1>Hello</h1>",
+		"docs/guide.html":     "guide",
+		"docs/img/logo.svg":   "<svg/>",
+		"docs/img/my pic.png": "png",
+		"zebra.txt":           "z",
+	})
+	first := deployToFakeKubo(t, folderName)
+
+	if first.rootCid != fakeCid(1, "site") {
+		t.Errorf("root cid = %s; want the cid Kubo reported for the root", first.rootCid)
+	}
+	if fake.addQuery.Get("pin") != "false" || fake.addQuery.Get("cid-version") != "1" {
+		t.Errorf("add query = %v", fake.addQuery)
+	}
+	// Every part must come after the directory it is in
+	announced := map[string]bool{}
+	for _, part := range fake.parts {
+		partPath, contentType, _ := strings.Cut(part, " ")
+		if parent := path.Dir(partPath); parent != "." && !announced[parent] {
+			t.Errorf("%s sent before its directory %s", partPath, parent)
+		}
+		if contentType == "application/x-directory" {
+			if announced[partPath] {
+				t.Errorf("directory %s announced twice", partPath)
+			}
+			announced[partPath] = true
+		}
+	}
+	for _, dirPath := range []string{"site", "site/docs", "site/docs/img"} {
+		if !announced[dirPath] {
+			t.Errorf("directory %s not announced; parts were %v", dirPath, fake.parts)
+		}
+	}
+	if content := fake.contents["site/docs/img/my pic.png"]; content != "png" {
+		t.Errorf("site/docs/img/my pic.png = %q", content)
+	}
+	if !fake.pinned[first.rootCid] {
+		t.Error("root not pinned")
+	}
+
+	records, err := first.GetRequiredDnsRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || *records[0].Name != "_dnslink.www.example.com." || *records[0].ResourceRecords[0].Value != `"dnslink=/ipfs/`+first.rootCid+`"` {
+		t.Errorf("records = %v; want only the DNSLink record", records)
+	}
+
+	second := deployToFakeKubo(t, folderName)
+	if second.rootCid == first.rootCid {
+		t.Fatal("the fake should give each add new cids")
+	}
+	if fake.pinned[first.rootCid] || !fake.pinned[second.rootCid] {
+		t.Errorf("pinned = %v; want only the second root", fake.pinned)
+	}
+	state, err := LoadDeploymentState("www.example.com")
+	if err != nil || state == nil || state.RootCid != second.rootCid {
+		t.Errorf("state = %+v, %v; want the second root recorded", state, err)
+	}
+}
+
+func TestIpfsAddError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v0/id" {
+			json.NewEncoder(w).Encode(map[string]string{"ID": "12D3KooWTest"})
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, `{"Message":"no space left on device"}`, http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	t.Setenv("IPFS_API_URL", server.URL)
+
+	manager, err := NewIpfsObjectStorageProviderManager("www.example.com", writeTestSite(t, map[string]string{"index.html": "hi"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.InstantiateClient(); err != nil {
+		t.Fatal(err)
+	}
+	err = manager.UploadFilesToNewInstance()
+	if err == nil || !strings.Contains(err.Error(), "no space left on device") {
+		t.Errorf("error = %v; want Kubo's message", err)
+	}
+}
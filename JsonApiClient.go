@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// jsonApiClient calls a REST API that speaks JSON and authenticates every request with the
+// same header, as the Netlify, GitLab and Gitea APIs do
+type jsonApiClient struct {
+	// serviceName names the API in errors, e.g. "netlify returned 404 Not Found"
+	serviceName     string
+	baseUrl         string
+	authHeaderName  string
+	authHeaderValue string
+	httpClient      *http.Client
+}
+
+func newJsonApiClient(serviceName string, baseUrl string, authHeaderName string, authHeaderValue string) *jsonApiClient {
+	return &jsonApiClient{
+		serviceName:     serviceName,
+		baseUrl:         baseUrl,
+		authHeaderName:  authHeaderName,
+		authHeaderValue: authHeaderValue,
+		httpClient:      &http.Client{},
+	}
+}
+
+// doJsonRequest sends in as JSON when provided and decodes the JSON response into out when
+// provided. The status code is returned even on failure.
+func (client *jsonApiClient) doJsonRequest(method string, path string, in any, out any) (int, error) {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(encoded)
+		contentType = "application/json"
+	}
+	return client.doRequest(method, path, body, contentType, out)
+}
+
+// doRequest sends body as is, for uploads of raw file content
+func (client *jsonApiClient) doRequest(method string, path string, body io.Reader, contentType string, out any) (int, error) {
+	if client == nil {
+		return 0, errors.New("api client not instantiated")
+	}
+	req, err := http.NewRequest(method, client.baseUrl+path, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(client.authHeaderName, client.authHeaderValue)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("%s returned %s: %s", client.serviceName, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode %s response: %w", client.serviceName, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
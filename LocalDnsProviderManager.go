@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// LocalDnsProviderManager upserts records into <output dir>/<domain>.zone.json, a JSON list of
+// records rather than a BIND zone file, in place of a real DNS provider. It pairs with
+// LocalObjectStorageProviderManager for credential-free runs.
+type LocalDnsProviderManager struct {
+	subdomainName      string
+	domainName         string
+	outputDir          string
+	resourceRecordSets []*types.ResourceRecordSet
+}
+
+type localDnsRecord struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Ttl    int64    `json:"ttl"`
+	Values []string `json:"values"`
+}
+
+func (localDnsProviderManager *LocalDnsProviderManager) InstantiateClient() error {
+	if outputDir := os.Getenv("HOSTIT_LOCAL_DIR"); outputDir != "" {
+		localDnsProviderManager.outputDir = outputDir
+	}
+	if err := os.MkdirAll(localDnsProviderManager.outputDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	fmt.Printf("Using local DNS records file %s (JSON) for DNS provider\n", localDnsProviderManager.zonePath())
+	return nil
+}
+
+func (localDnsProviderManager *LocalDnsProviderManager) VerifyDomainExists() (bool, error) {
+	return true, nil
+}
+
+func (localDnsProviderManager *LocalDnsProviderManager) AddSubdomainRecords() error {
+	if len(localDnsProviderManager.resourceRecordSets) == 0 {
+		return errors.New("no resource record sets provided")
+	}
+
+	var records []localDnsRecord
+	existing, err := os.ReadFile(localDnsProviderManager.zonePath())
+	if err == nil {
+		if err := json.Unmarshal(existing, &records); err != nil {
+			return fmt.Errorf("failed to parse DNS records file: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for _, rrset := range localDnsProviderManager.resourceRecordSets {
+		if rrset == nil || rrset.Name == nil {
+			continue
+		}
+		record := localDnsRecord{
+			Name: *rrset.Name,
+			Type: string(rrset.Type),
+		}
+		if rrset.TTL != nil {
+			record.Ttl = *rrset.TTL
+		}
+		for _, resourceRecord := range rrset.ResourceRecords {
+			if resourceRecord.Value != nil {
+				record.Values = append(record.Values, *resourceRecord.Value)
+			}
+		}
+		// Upsert semantics match the Route 53 change batch used by AwsDnsProviderManager
+		replaced := false
+		for index := range records {
+			if strings.EqualFold(records[index].Name, record.Name) && records[index].Type == record.Type {
+				records[index] = record
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			records = append(records, record)
+		}
+	}
+
+	encoded, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(localDnsProviderManager.zonePath(), append(encoded, '\n'), 0o644)
+}
+
+func (localDnsProviderManager *LocalDnsProviderManager) zonePath() string {
+	return filepath.Join(localDnsProviderManager.outputDir, localDnsProviderManager.domainName+".zone.json")
+}
+
+func NewLocalDnsProviderManager(subdomainName string, domainName string, resourceRecordSets []*types.ResourceRecordSet) (*LocalDnsProviderManager, error) {
+	return &LocalDnsProviderManager{
+		subdomainName:      subdomainName,
+		domainName:         domainName,
+		outputDir:          defaultLocalOutputDir,
+		resourceRecordSets: resourceRecordSets,
+	}, nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+const defaultLocalOutputDir = "hostit-output"
+
+// LocalObjectStorageProviderManager writes the site into <output dir>/<domain> instead of a
+// cloud provider, alongside a <domain>.hostit.json metadata file describing what was deployed.
+// It needs no credentials, which makes it usable for end-to-end runs and air-gapped builds.
+type LocalObjectStorageProviderManager struct {
+	domainName string
+	folderName string
+	options    DeployOptions
+	outputDir  string
+	cnameValue string
+	metadata   *localSiteMetadata
+}
+
+type localSiteMetadata struct {
+	Domain     string              `json:"domain"`
+	DeployedAt time.Time           `json:"deployedAt"`
+	Files      []localFileMetadata `json:"files"`
+}
+
+type localFileMetadata struct {
+	Path            string `json:"path"`
+	Size            int64  `json:"size"`
+	Sha1            string `json:"sha1"`
+	ContentType     string `json:"contentType"`
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+	CacheControl    string `json:"cacheControl,omitempty"`
+}
+
+func (localObjectStorageProviderManager *LocalObjectStorageProviderManager) InstantiateClient() error {
+	if outputDir := os.Getenv("HOSTIT_LOCAL_DIR"); outputDir != "" {
+		localObjectStorageProviderManager.outputDir = outputDir
+	}
+	if cnameValue := os.Getenv("HOSTIT_LOCAL_CNAME_TARGET"); cnameValue != "" {
+		localObjectStorageProviderManager.cnameValue = cnameValue
+	}
+	absOutputDir, err := filepath.Abs(localObjectStorageProviderManager.outputDir)
+	if err != nil {
+		return fmt.Errorf("invalid output directory '%s': %w", localObjectStorageProviderManager.outputDir, err)
+	}
+	localObjectStorageProviderManager.outputDir = absOutputDir
+	fmt.Printf("Using local directory %s for object storage provider\n", absOutputDir)
+	return nil
+}
+
+func (localObjectStorageProviderManager *LocalObjectStorageProviderManager) VerifyNamespace() (bool, error) {
+	// A site deployed earlier is overwritten in place, like a redeploy to any other backend
+	_, err := os.Stat(localObjectStorageProviderManager.siteDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error checking site directory: %w", err)
+	}
+	fmt.Printf("Existing site in %s will be replaced\n", localObjectStorageProviderManager.siteDir())
+	return true, nil
+}
+
+func (localObjectStorageProviderManager *LocalObjectStorageProviderManager) CreateStorageInstance() error {
+	if err := os.MkdirAll(localObjectStorageProviderManager.siteDir(), 0o755); err != nil {
+		return fmt.Errorf("failed to create site directory: %w", err)
+	}
+	return nil
+}
+
+func (localObjectStorageProviderManager *LocalObjectStorageProviderManager) UploadFilesToNewInstance() error {
+	const maxFileSizeBytes int64 = 1 * 1024 * 1024 * 1024 // 1GB
+
+	finder := NewUploadFileFinder()
+	filesToUpload, err := finder.FindFiles(localObjectStorageProviderManager.folderName, maxFileSizeBytes)
+	if err != nil {
+		return err
+	}
+	// Redirect and header rules configure a host rather than being served, as on S3
+	filesToUpload = slices.DeleteFunc(filesToUpload, func(repoPath string) bool {
+		return IsRedirectRulesFile(repoPath) || IsHeaderRulesFile(repoPath)
+	})
+
+	siteDir := localObjectStorageProviderManager.siteDir()
+	contentTypeResolver := NewContentTypeResolver(localObjectStorageProviderManager.options.ContentTypeOverrides)
+	metadata := &localSiteMetadata{
+		Domain:     localObjectStorageProviderManager.domainName,
+		DeployedAt: time.Now().UTC(),
+	}
+	hasCNAMEAtRoot := false
+	for _, repoPath := range filesToUpload {
+		fullPath := filepath.Join(localObjectStorageProviderManager.folderName, filepath.FromSlash(repoPath))
+		targetPath := filepath.Join(siteDir, filepath.FromSlash(repoPath))
+		if repoPath == "CNAME" {
+			hasCNAMEAtRoot = true
+			if err := localObjectStorageProviderManager.writeCname(targetPath); err != nil {
+				return err
+			}
+		} else if err := copyLocalFile(fullPath, targetPath); err != nil {
+			return fmt.Errorf("failed to copy '%s': %w", repoPath, err)
+		}
+		fileMetadata, err := localFileMetadataFor(contentTypeResolver, repoPath, targetPath)
+		if err != nil {
+			return err
+		}
+		fileMetadata.CacheControl, _ = localObjectStorageProviderManager.options.CacheControlRules.Match(repoPath)
+		metadata.Files = append(metadata.Files, fileMetadata)
+		log.Printf("Copied '%s'", repoPath)
+	}
+	// Mirror GitHub Pages, where hostit always places a CNAME file at the root
+	if !hasCNAMEAtRoot {
+		cnamePath := filepath.Join(siteDir, "CNAME")
+		if err := localObjectStorageProviderManager.writeCname(cnamePath); err != nil {
+			return err
+		}
+		fileMetadata, err := localFileMetadataFor(contentTypeResolver, "CNAME", cnamePath)
+		if err != nil {
+			return err
+		}
+		metadata.Files = append(metadata.Files, fileMetadata)
+	}
+
+	deployedPaths := NewSet[string]()
+	for _, fileMetadata := range metadata.Files {
+		deployedPaths.Add(fileMetadata.Path)
+	}
+	if err := removeStaleLocalFiles(siteDir, deployedPaths); err != nil {
+		return err
+	}
+	localObjectStorageProviderManager.metadata = metadata
+	return nil
+}
+
+func (localObjectStorageProviderManager *LocalObjectStorageProviderManager) CreateAvailableDomain() error {
+	if localObjectStorageProviderManager.metadata == nil {
+		return errors.New("no files uploaded")
+	}
+	encoded, err := json.MarshalIndent(localObjectStorageProviderManager.metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	metadataPath := filepath.Join(localObjectStorageProviderManager.outputDir, localObjectStorageProviderManager.domainName+".hostit.json")
+	if err := os.WriteFile(metadataPath, append(encoded, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write site metadata: %w", err)
+	}
+	return nil
+}
+
+func (localObjectStorageProviderManager *LocalObjectStorageProviderManager) GetRequiredDnsRecords() ([]*types.ResourceRecordSet, error) {
+	return []*types.ResourceRecordSet{
+		{
+			Name: aws.String(localObjectStorageProviderManager.domainName + "."),
+			Type: types.RRTypeCname,
+			TTL:  aws.Int64(300),
+			ResourceRecords: []types.ResourceRecord{
+				{
+					Value: aws.String(localObjectStorageProviderManager.cnameValue),
+				},
+			},
+		},
+	}, nil
+}
+
+func (localObjectStorageProviderManager *LocalObjectStorageProviderManager) FinalizeHttps() error {
+	return nil
+}
+
+func (localObjectStorageProviderManager *LocalObjectStorageProviderManager) siteDir() string {
+	return filepath.Join(localObjectStorageProviderManager.outputDir, localObjectStorageProviderManager.domainName)
+}
+
+func (localObjectStorageProviderManager *LocalObjectStorageProviderManager) writeCname(targetPath string) error {
+	if err := os.WriteFile(targetPath, []byte(strings.TrimSpace(localObjectStorageProviderManager.domainName)), 0o644); err != nil {
+		return fmt.Errorf("failed to write CNAME: %w", err)
+	}
+	return nil
+}
+
+func localFileMetadataFor(contentTypeResolver *ContentTypeResolver, repoPath string, targetPath string) (localFileMetadata, error) {
+	info, err := os.Stat(targetPath)
+	if err != nil {
+		return localFileMetadata{}, err
+	}
+	digest, err := sha1FileDigest(targetPath)
+	if err != nil {
+		return localFileMetadata{}, err
+	}
+	contentMetadata, err := contentTypeResolver.Resolve(repoPath, targetPath)
+	if err != nil {
+		return localFileMetadata{}, err
+	}
+	return localFileMetadata{
+		Path:            repoPath,
+		Size:            info.Size(),
+		Sha1:            digest,
+		ContentType:     contentMetadata.ContentType,
+		ContentEncoding: contentMetadata.ContentEncoding,
+	}, nil
+}
+
+// removeStaleLocalFiles deletes the files under siteDir an earlier deploy left that are not in
+// deployedPaths, then any directories that leaves empty
+func removeStaleLocalFiles(siteDir string, deployedPaths Set[string]) error {
+	var stalePaths, dirPaths []string
+	err := filepath.WalkDir(siteDir, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if fullPath != siteDir {
+				dirPaths = append(dirPaths, fullPath)
+			}
+			return nil
+		}
+		relativePath, err := filepath.Rel(siteDir, fullPath)
+		if err != nil {
+			return err
+		}
+		if !deployedPaths.Contains(filepath.ToSlash(relativePath)) {
+			stalePaths = append(stalePaths, fullPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list site directory: %w", err)
+	}
+	for _, stalePath := range stalePaths {
+		if err := os.Remove(stalePath); err != nil {
+			return fmt.Errorf("failed to remove '%s': %w", stalePath, err)
+		}
+		log.Printf("Removed '%s'", stalePath)
+	}
+	// Children are listed after their parents, so going backwards empties parents last
+	for index := len(dirPaths) - 1; index >= 0; index-- {
+		if entries, err := os.ReadDir(dirPaths[index]); err == nil && len(entries) == 0 {
+			if err := os.Remove(dirPaths[index]); err != nil {
+				return fmt.Errorf("failed to remove '%s': %w", dirPaths[index], err)
+			}
+		}
+	}
+	return nil
+}
+
+func copyLocalFile(sourcePath string, targetPath string) error {
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0o755); err != nil {
+		return err
+	}
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.Create(targetPath)
+	if err != nil {
+		return err
+	}
+	_, copyErr := io.Copy(target, source)
+	closeErr := target.Close()
+	if copyErr != nil {
+		return copyErr
+	}
+	return closeErr
+}
+
+func NewLocalObjectStorageProviderManager(domainName string, folderName string, options DeployOptions) (*LocalObjectStorageProviderManager, error) {
+	if folderName == "" {
+		return nil, errors.New("folderName must not be empty")
+	}
+	return &LocalObjectStorageProviderManager{
+		domainName: domainName,
+		folderName: folderName,
+		options:    options,
+		outputDir:  defaultLocalOutputDir,
+		cnameValue: "localhost",
+		metadata:   nil,
+	}, nil
+}
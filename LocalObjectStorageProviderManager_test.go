@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func deployToLocalDir(t *testing.T, folderName string) *LocalObjectStorageProviderManager {
+	t.Helper()
+	manager, err := NewLocalObjectStorageProviderManager("www.example.com", folderName, DeployOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.InstantiateClient(); err != nil {
+		t.Fatal(err)
+	}
+	available, err := manager.VerifyNamespace()
+	if err != nil || !available {
+		t.Fatalf("VerifyNamespace() = %v, %v; want an earlier deploy to be replaced", available, err)
+	}
+	for _, step := range []func() error{manager.CreateStorageInstance, manager.UploadFilesToNewInstance, manager.CreateAvailableDomain} {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return manager
+}
+
+func TestLocalRedeploy(t *testing.T) {
+	outputDir := t.TempDir()
+	t.Setenv("HOSTIT_LOCAL_DIR", outputDir)
+	siteDir := filepath.Join(outputDir, "www.example.com")
+
+	deployToLocalDir(t, writeTestSite(t, map[string]string{
+		"index.html":         "first",
+		"old/page.html":      "old",
+		"old/deep/page.html": "old",
+		"_redirects":         "/from /to 301",
+		"_headers":           "/*\n  X-Frame-Options: DENY",
+	}))
+	for _, rulesFile := range []string{"_redirects", "_headers"} {
+		if _, err := os.Stat(filepath.Join(siteDir, rulesFile)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s was copied into the site", rulesFile)
+		}
+	}
+
+	manager := deployToLocalDir(t, writeTestSite(t, map[string]string{
+		"index.html": "second",
+	}))
+	served, err := os.ReadFile(filepath.Join(siteDir, "index.html"))
+	if err != nil || string(served) != "second" {
+		t.Errorf("index.html = %q, %v; want the new content", served, err)
+	}
+	if _, err := os.Stat(filepath.Join(siteDir, "old")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("files of the earlier deploy were left behind: %v", err)
+	}
+	if cname, err := os.ReadFile(filepath.Join(siteDir, "CNAME")); err != nil || string(cname) != "www.example.com" {
+		t.Errorf("CNAME = %q, %v", cname, err)
+	}
+	if len(manager.metadata.Files) != 2 {
+		t.Errorf("metadata lists %v; want index.html and CNAME", manager.metadata.Files)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+const defaultNetlifyApiUrl = "https://api.netlify.com/api/v1"
+
+type NetlifyObjectStorageProviderManager struct {
+	domainName string
+	folderName string
+	siteName   string
+	apiUrl     string
+	apiClient  *jsonApiClient
+	siteId     string
+}
+
+type netlifySite struct {
+	Id           string `json:"id"`
+	Name         string `json:"name"`
+	CustomDomain string `json:"custom_domain"`
+	SslUrl       string `json:"ssl_url"`
+}
+
+type netlifyDeploy struct {
+	Id       string   `json:"id"`
+	State    string   `json:"state"`
+	Required []string `json:"required"`
+}
+
+func (netlifyObjectStorageProviderManager *NetlifyObjectStorageProviderManager) InstantiateClient() error {
+	token := os.Getenv("NETLIFY_AUTH_TOKEN")
+	if token == "" {
+		return errors.New("NETLIFY_AUTH_TOKEN not set")
+	}
+	// NETLIFY_API_URL allows pointing hostit at a fake of the Netlify API
+	if apiUrl := os.Getenv("NETLIFY_API_URL"); apiUrl != "" {
+		netlifyObjectStorageProviderManager.apiUrl = strings.TrimSuffix(apiUrl, "/")
+	}
+	netlifyObjectStorageProviderManager.apiClient = newJsonApiClient("netlify", netlifyObjectStorageProviderManager.apiUrl, "Authorization", "Bearer "+token)
+	return nil
+}
+
+func (netlifyObjectStorageProviderManager *NetlifyObjectStorageProviderManager) VerifyNamespace() (bool, error) {
+	statusCode, err := netlifyObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodGet, "/sites/"+netlifyObjectStorageProviderManager.siteName+".netlify.app", nil, nil)
+	if statusCode == http.StatusNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("error checking netlify site: %w", err)
+	}
+	return false, nil
+}
+
+func (netlifyObjectStorageProviderManager *NetlifyObjectStorageProviderManager) CreateStorageInstance() error {
+	var site netlifySite
+	_, err := netlifyObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodPost, "/sites", map[string]string{
+		"name": netlifyObjectStorageProviderManager.siteName,
+	}, &site)
+	if err != nil {
+		return fmt.Errorf("failed to create netlify site: %w", err)
+	}
+	if site.Id == "" {
+		return errors.New("unexpected empty site id from netlify")
+	}
+	netlifyObjectStorageProviderManager.siteId = site.Id
+	if site.Name != "" {
+		netlifyObjectStorageProviderManager.siteName = site.Name
+	}
+	fmt.Printf("Created Netlify site %s\n", netlifyObjectStorageProviderManager.siteName)
+	return nil
+}
+
+func (netlifyObjectStorageProviderManager *NetlifyObjectStorageProviderManager) UploadFilesToNewInstance() error {
+	// Netlify rejects deploy files larger than 50 MB through the API
+	const maxFileSizeBytes = 50 * 1024 * 1024
+
+	if netlifyObjectStorageProviderManager.siteId == "" {
+		return errors.New("netlify site not created; call CreateStorageInstance first")
+	}
+
+	finder := NewUploadFileFinder()
+	filesToUpload, err := finder.FindFiles(netlifyObjectStorageProviderManager.folderName, maxFileSizeBytes)
+	if err != nil {
+		return err
+	}
+
+	// Netlify deduplicates by content digest, so several paths can share one required digest
+	fileDigests := make(map[string]string, len(filesToUpload))
+	pathsByDigest := make(map[string][]string)
+	for _, repoPath := range filesToUpload {
+		fullPath := filepath.Join(netlifyObjectStorageProviderManager.folderName, filepath.FromSlash(repoPath))
+		digest, err := sha1FileDigest(fullPath)
+		if err != nil {
+			return err
+		}
+		deployPath := "/" + repoPath
+		fileDigests[deployPath] = digest
+		pathsByDigest[digest] = append(pathsByDigest[digest], repoPath)
+	}
+
+	var deploy netlifyDeploy
+	_, err = netlifyObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodPost, "/sites/"+netlifyObjectStorageProviderManager.siteId+"/deploys", map[string]any{
+		"files": fileDigests,
+	}, &deploy)
+	if err != nil {
+		return fmt.Errorf("failed to create netlify deploy: %w", err)
+	}
+	if deploy.Id == "" {
+		return errors.New("unexpected empty deploy id from netlify")
+	}
+	log.Printf("Netlify requires %d of %d files", len(deploy.Required), len(filesToUpload))
+
+	for _, digest := range deploy.Required {
+		repoPaths, ok := pathsByDigest[digest]
+		if !ok || len(repoPaths) == 0 {
+			return fmt.Errorf("netlify requested unknown file digest '%s'", digest)
+		}
+		repoPath := repoPaths[0]
+		fullPath := filepath.Join(netlifyObjectStorageProviderManager.folderName, filepath.FromSlash(repoPath))
+		data, err := os.ReadFile(fullPath)
+		if err != nil {
+			return fmt.Errorf("failed to read file '%s': %w", fullPath, err)
+		}
+		err = netlifyObjectStorageProviderManager.uploadDeployFile(deploy.Id, repoPath, data)
+		if err != nil {
+			return fmt.Errorf("failed to upload '%s': %w", repoPath, err)
+		}
+		log.Printf("Uploaded '%s'", repoPath)
+	}
+	return nil
+}
+
+func (netlifyObjectStorageProviderManager *NetlifyObjectStorageProviderManager) CreateAvailableDomain() error {
+	if netlifyObjectStorageProviderManager.siteId == "" {
+		return errors.New("netlify site not created")
+	}
+	_, err := netlifyObjectStorageProviderManager.apiClient.doJsonRequest(http.MethodPatch, "/sites/"+netlifyObjectStorageProviderManager.siteId, map[string]string{
+		"custom_domain": netlifyObjectStorageProviderManager.domainName,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set netlify custom domain: %w", err)
+	}
+	return nil
+}
+
+func (netlifyObjectStorageProviderManager *NetlifyObjectStorageProviderManager) GetRequiredDnsRecords() ([]*types.ResourceRecordSet, error) {
+	return []*types.ResourceRecordSet{
+		{
+			Name: aws.String(netlifyObjectStorageProviderManager.domainName + "."),
+			Type: types.RRTypeCname,
+			TTL:  aws.Int64(300),
+			ResourceRecords: []types.ResourceRecord{
+				{
+					Value: aws.String(netlifyObjectStorageProviderManager.siteName + ".netlify.app"),
+				},
+			},
+		},
+	}, nil
+}
+
+func (netlifyObjectStorageProviderManager *NetlifyObjectStorageProviderManager) FinalizeHttps() error {
+	return nil
+}
+
+func (netlifyObjectStorageProviderManager *NetlifyObjectStorageProviderManager) uploadDeployFile(deployId string, repoPath string, data []byte) error {
+	escapedPath := (&url.URL{Path: repoPath}).EscapedPath()
+	_, err := netlifyObjectStorageProviderManager.apiClient.doRequest(http.MethodPut, "/deploys/"+deployId+"/files/"+escapedPath, bytes.NewReader(data), "application/octet-stream", nil)
+	return err
+}
+
+func sha1FileDigest(fullPath string) (string, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open '%s': %w", fullPath, err)
+	}
+	defer f.Close()
+	hash := sha1.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to hash '%s': %w", fullPath, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func NewNetlifyObjectStorageProviderManager(domainName string, folderName string) (*NetlifyObjectStorageProviderManager, error) {
+	if folderName == "" {
+		return nil, errors.New("folderName must not be empty")
+	}
+	// Netlify site names are limited to lowercase letters, digits and hyphens
+	siteName := strings.ToLower(strings.ReplaceAll(domainName, ".", "-"))
+	if len(siteName) > 63 {
+		siteName = strings.TrimRight(siteName[:63], "-")
+	}
+	return &NetlifyObjectStorageProviderManager{
+		domainName: domainName,
+		folderName: folderName,
+		siteName:   siteName,
+		apiUrl:     defaultNetlifyApiUrl,
+		apiClient:  nil,
+		siteId:     "",
+	}, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeNetlify records the requests made to a fake of the Netlify API
+type fakeNetlify struct {
+	mutex         sync.Mutex
+	requests      []string
+	deployedFiles map[string]string
+	uploads       map[string]string
+}
+
+func newNetlifyTestManager(t *testing.T, handler http.Handler, folderName string) *NetlifyObjectStorageProviderManager {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	t.Setenv("NETLIFY_AUTH_TOKEN", "test-token")
+	t.Setenv("NETLIFY_API_URL", server.URL+"/api/v1/")
+	manager, err := NewNetlifyObjectStorageProviderManager("www.example.com", folderName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.InstantiateClient(); err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+func (fake *fakeNetlify) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.requests = append(fake.requests, r.Method+" "+r.URL.Path)
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/sites/www-example-com.netlify.app":
+		http.Error(w, `{"code":404,"message":"Not Found"}`, http.StatusNotFound)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/sites":
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["name"] != "www-example-com" {
+			http.Error(w, "bad site name", http.StatusUnprocessableEntity)
+			return
+		}
+		json.NewEncoder(w).Encode(netlifySite{Id: "site-1", Name: "www-example-com-1"})
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/sites/site-1/deploys":
+		var body struct {
+			Files map[string]string `json:"files"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fake.deployedFiles = body.Files
+		// Pretend the site already has the stylesheet, so only the HTML content is required
+		required := []string{body.Files["/index.html"]}
+		json.NewEncoder(w).Encode(netlifyDeploy{Id: "deploy-1", State: "uploading", Required: required})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/api/v1/deploys/deploy-1/files/"):
+		data, _ := io.ReadAll(r.Body)
+		fake.uploads[strings.TrimPrefix(r.URL.Path, "/api/v1/deploys/deploy-1/files/")] = string(data)
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPatch && r.URL.Path == "/api/v1/sites/site-1":
+		json.NewEncoder(w).Encode(netlifySite{Id: "site-1", CustomDomain: "www.example.com"})
+	default:
+		http.Error(w, "unexpected request", http.StatusNotImplemented)
+	}
+}
+
+func writeTestSite(t *testing.T, files map[string]string) string {
+	t.Helper()
+	folderName := t.TempDir()
+	for repoPath, content := range files {
+		fullPath := filepath.Join(folderName, filepath.FromSlash(repoPath))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return folderName
+}
+
+func TestNetlifyDeploy(t *testing.T) {
+	folderName := writeTestSite(t, map[string]string{
+		"index.html":      "<h1>Hello</h1>",
+		"copy/index.html": "<h1>Hello</h1>",
+		"css/site.css":    "body { margin: 0 }",
+	})
+	fake := &fakeNetlify{uploads: map[string]string{}}
+	manager := newNetlifyTestManager(t, fake, folderName)
+
+	available, err := manager.VerifyNamespace()
+	if err != nil || !available {
+		t.Fatalf("VerifyNamespace() = %v, %v; want true, nil", available, err)
+	}
+	if err := manager.CreateStorageInstance(); err != nil {
+		t.Fatal(err)
+	}
+	if manager.siteId != "site-1" || manager.siteName != "www-example-com-1" {
+		t.Errorf("site = %s %s; want the id and name Netlify returned", manager.siteId, manager.siteName)
+	}
+	if err := manager.UploadFilesToNewInstance(); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.CreateAvailableDomain(); err != nil {
+		t.Fatal(err)
+	}
+
+	var deployPaths []string
+	for deployPath := range fake.deployedFiles {
+		deployPaths = append(deployPaths, deployPath)
+	}
+	sort.Strings(deployPaths)
+	if strings.Join(deployPaths, " ") != "/copy/index.html /css/site.css /index.html" {
+		t.Errorf("deploy listed %v", deployPaths)
+	}
+	if digest := fake.deployedFiles["/index.html"]; digest != fake.deployedFiles["/copy/index.html"] {
+		t.Errorf("identical files have digests %s and %s", digest, fake.deployedFiles["/copy/index.html"])
+	}
+	// Both HTML files share the required digest, which is uploaded once
+	if len(fake.uploads) != 1 {
+		t.Fatalf("uploaded %v; want only the required digest", fake.uploads)
+	}
+	for uploadPath, content := range fake.uploads {
+		if !strings.HasSuffix(uploadPath, "index.html") || content != "<h1>Hello</h1>" {
+			t.Errorf("uploaded %s with %q", uploadPath, content)
+		}
+	}
+	if lastRequest := fake.requests[len(fake.requests)-1]; lastRequest != "PATCH /api/v1/sites/site-1" {
+		t.Errorf("last request = %s; want the custom domain set on the site", lastRequest)
+	}
+}
+
+func TestNetlifyVerifyNamespaceExistingSite(t *testing.T) {
+	manager := newNetlifyTestManager(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(netlifySite{Id: "site-1", Name: "www-example-com"})
+	}), t.TempDir())
+	available, err := manager.VerifyNamespace()
+	if err != nil || available {
+		t.Errorf("VerifyNamespace() = %v, %v; want false, nil", available, err)
+	}
+}
+
+func TestNetlifyErrorStatuses(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		run     func(manager *NetlifyObjectStorageProviderManager) error
+		wantErr string
+	}{
+		{
+			name:   "lookup",
+			status: http.StatusInternalServerError,
+			run: func(manager *NetlifyObjectStorageProviderManager) error {
+				_, err := manager.VerifyNamespace()
+				return err
+			},
+			wantErr: "500",
+		},
+		{
+			name:    "create site",
+			status:  http.StatusUnprocessableEntity,
+			run:     (*NetlifyObjectStorageProviderManager).CreateStorageInstance,
+			wantErr: "422",
+		},
+		{
+			name:   "deploy",
+			status: http.StatusUnauthorized,
+			run: func(manager *NetlifyObjectStorageProviderManager) error {
+				manager.siteId = "site-1"
+				return manager.UploadFilesToNewInstance()
+			},
+			wantErr: "401",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			folderName := writeTestSite(t, map[string]string{"index.html": "hello"})
+			manager := newNetlifyTestManager(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"message":"failed"}`, test.status)
+			}), folderName)
+			err := test.run(manager)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("error = %v; want one mentioning %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestNetlifyUploadRejected(t *testing.T) {
+	folderName := writeTestSite(t, map[string]string{"index.html": "hello"})
+	manager := newNetlifyTestManager(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var body struct {
+				Files map[string]string `json:"files"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			json.NewEncoder(w).Encode(netlifyDeploy{Id: "deploy-1", Required: []string{body.Files["/index.html"]}})
+			return
+		}
+		http.Error(w, "storage full", http.StatusInsufficientStorage)
+	}), folderName)
+	manager.siteId = "site-1"
+	err := manager.UploadFilesToNewInstance()
+	if err == nil || !strings.Contains(err.Error(), "index.html") || !strings.Contains(err.Error(), "507") {
+		t.Errorf("error = %v; want the failed upload of index.html", err)
+	}
+}
+
+func TestNetlifyUnknownRequiredDigest(t *testing.T) {
+	folderName := writeTestSite(t, map[string]string{"index.html": "hello"})
+	manager := newNetlifyTestManager(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(netlifyDeploy{Id: "deploy-1", Required: []string{"0000"}})
+	}), folderName)
+	manager.siteId = "site-1"
+	if err := manager.UploadFilesToNewInstance(); err == nil || !strings.Contains(err.Error(), "0000") {
+		t.Errorf("error = %v; want the unknown digest reported", err)
+	}
+}
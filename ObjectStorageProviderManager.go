@@ -11,3 +11,23 @@ type ObjectStorageProviderManager interface {
 	GetRequiredDnsRecords() ([]*types.ResourceRecordSet, error)
 	FinalizeHttps() error
 }
+
+// CacheInvalidator is implemented by providers that serve content through a CDN cache which
+// must be cleared after an update
+type CacheInvalidator interface {
+	InvalidateCache() error
+}
+
+// SupersededContentPruner is implemented by providers that must keep serving the previous
+// content until the DNS records from GetRequiredDnsRecords are in place
+type SupersededContentPruner interface {
+	PruneSupersededContent() error
+}
+
+// DeploymentAdopter is implemented by providers that can take over the resources of an earlier
+// deploy after VerifyNamespace reports them, updating them in place instead of failing
+type DeploymentAdopter interface {
+	// ExistingDeployment describes the resources that would be adopted
+	ExistingDeployment() string
+	AdoptExistingDeployment() error
+}
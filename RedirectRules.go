@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// redirectRulesFileNames are read from the root of the upload folder. _redirects uses the
+// Netlify syntax so migrated sites keep working; hostit.redirects takes the same syntax.
+var redirectRulesFileNames = []string{"_redirects", "hostit.redirects"}
+
+// RedirectRule sends requests for From to To. A From ending in /* is a splat that matches
+// everything below it, and :splat in To is replaced with the matched remainder.
+type RedirectRule struct {
+	From   string
+	To     string
+	Status int
+}
+
+func (rule RedirectRule) IsSplat() bool {
+	return strings.HasSuffix(rule.From, "/*")
+}
+
+// IsRedirectRulesFile reports whether repoPath is a rules file rather than site content
+func IsRedirectRulesFile(repoPath string) bool {
+	for _, name := range redirectRulesFileNames {
+		if repoPath == name {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadRedirectRules reads every rules file at the root of folderName, returning nil if there
+// are none
+func LoadRedirectRules(folderName string) ([]RedirectRule, error) {
+	var rules []RedirectRule
+	for _, name := range redirectRulesFileNames {
+		f, err := os.Open(filepath.Join(folderName, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open '%s': %w", name, err)
+		}
+		fileRules, err := ParseRedirectRules(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid redirect rules in '%s': %w", name, err)
+		}
+		rules = append(rules, fileRules...)
+	}
+	return rules, nil
+}
+
+// ParseRedirectRules reads "from to [status]" lines. Blank lines and # comments are skipped,
+// the status defaults to 301 and a trailing ! (forced) is accepted since every rule applies
+// whether or not a file exists at the path.
+func ParseRedirectRules(reader io.Reader) ([]RedirectRule, error) {
+	var rules []RedirectRule
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected 'from to [status]', got '%s'", lineNumber, line)
+		}
+		rule := RedirectRule{From: fields[0], To: fields[1], Status: 301}
+		if len(fields) == 3 {
+			status, err := strconv.Atoi(strings.TrimSuffix(fields[2], "!"))
+			if err != nil || (status != 301 && status != 302) {
+				return nil, fmt.Errorf("line %d: unsupported status '%s' (expected 301 or 302)", lineNumber, fields[2])
+			}
+			rule.Status = status
+		}
+		if !strings.HasPrefix(rule.From, "/") {
+			return nil, fmt.Errorf("line %d: '%s' must start with /", lineNumber, rule.From)
+		}
+		if strings.Contains(rule.From, "/:") {
+			return nil, fmt.Errorf("line %d: placeholders in '%s' are not supported, only a trailing /*", lineNumber, rule.From)
+		}
+		if strings.Contains(strings.TrimSuffix(rule.From, "/*"), "*") {
+			return nil, fmt.Errorf("line %d: '%s' may only use * as its last path segment", lineNumber, rule.From)
+		}
+		if strings.Contains(rule.To, ":splat") && !rule.IsSplat() {
+			return nil, fmt.Errorf("line %d: ':splat' in '%s' needs a from path ending in /*", lineNumber, rule.To)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
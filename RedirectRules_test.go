@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRedirectRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []RedirectRule
+		wantErr string
+	}{
+		{
+			name:  "status defaults to 301",
+			input: "# moved\n/old /new\n\n/blog/* https://blog.example.com/:splat 302\n/docs /guide 301!\n",
+			want: []RedirectRule{
+				{From: "/old", To: "/new", Status: 301},
+				{From: "/blog/*", To: "https://blog.example.com/:splat", Status: 302},
+				// A trailing ! is accepted since every rule is applied anyway
+				{From: "/docs", To: "/guide", Status: 301},
+			},
+		},
+		{name: "no file", input: "", want: nil},
+		{name: "missing target", input: "/old\n", wantErr: "line 1: expected 'from to [status]', got '/old'"},
+		{name: "extra field", input: "/old /new 301 Country=us\n", wantErr: "line 1: expected 'from to [status]'"},
+		{name: "rewrite status", input: "/old /new 200\n", wantErr: "line 1: unsupported status '200'"},
+		{name: "status not a number", input: "/old /new permanent\n", wantErr: "unsupported status 'permanent'"},
+		{name: "relative from", input: "\nold /new\n", wantErr: "line 2: 'old' must start with /"},
+		{name: "placeholder", input: "/news/:year /blog\n", wantErr: "placeholders in '/news/:year' are not supported"},
+		{name: "inner wildcard", input: "/*/old /new\n", wantErr: "may only use * as its last path segment"},
+		{name: "partial wildcard", input: "/old* /new\n", wantErr: "may only use * as its last path segment"},
+		{name: "splat without wildcard", input: "/old /new/:splat\n", wantErr: "':splat' in '/new/:splat' needs a from path ending in /*"},
+	}
+	for _, test := range tests {
+		rules, err := ParseRedirectRules(strings.NewReader(test.input))
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error = %v; want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(rules, test.want) {
+			t.Errorf("%s: rules = %+v; want %+v", test.name, rules, test.want)
+		}
+	}
+}
+
+func TestIsRedirectRulesFile(t *testing.T) {
+	tests := []struct {
+		repoPath string
+		want     bool
+	}{
+		{"_redirects", true},
+		{"hostit.redirects", true},
+		{"docs/_redirects", false},
+		{"_headers", false},
+	}
+	for _, test := range tests {
+		if got := IsRedirectRulesFile(test.repoPath); got != test.want {
+			t.Errorf("IsRedirectRulesFile(%s) = %v; want %v", test.repoPath, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReleaseManifest records what a deploy put live, so that a later rollback can restore it.
+// For S3 sites Objects maps every key to the object version the release served. GitHub releases
+// are read back from the commit history and carry the commit instead.
+type ReleaseManifest struct {
+	Id           string            `json:"id"`
+	Domain       string            `json:"domain"`
+	Backend      string            `json:"backend"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	RestoredFrom string            `json:"restoredFrom,omitempty"`
+	Objects      map[string]string `json:"objects,omitempty"`
+	// Prefix is set for atomic releases, which keep their own copy of the site under it
+	Prefix string `json:"prefix,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	Files  int    `json:"files,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+// NewReleaseId names a release after the current time, which keeps releases in deploy order.
+// Nanoseconds keep two deploys within the same second apart.
+func NewReleaseId() string {
+	return time.Now().UTC().Format("20060102T150405.000000000Z")
+}
+
+func releaseManifestDir(domainName string) (string, error) {
+	stateDir, err := deploymentStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, domainName+".releases"), nil
+}
+
+func (manifest *ReleaseManifest) Save() error {
+	if manifest.Domain == "" || manifest.Id == "" {
+		return errors.New("release manifest has no domain or id")
+	}
+	manifestDir, err := releaseManifestDir(manifest.Domain)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(manifestDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create release directory: %w", err)
+	}
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(manifestDir, manifest.Id+".json"), append(encoded, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write release manifest: %w", err)
+	}
+	return nil
+}
+
+// LoadReleaseManifests returns the recorded releases of domainName, oldest first
+func LoadReleaseManifests(domainName string) ([]*ReleaseManifest, error) {
+	manifestDir, err := releaseManifestDir(domainName)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(manifestDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read releases: %w", err)
+	}
+	var manifests []*ReleaseManifest
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(manifestDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read release manifest: %w", err)
+		}
+		var manifest ReleaseManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse release manifest '%s': %w", entry.Name(), err)
+		}
+		manifests = append(manifests, &manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].Id < manifests[j].Id
+	})
+	return manifests, nil
+}
+
+// DeleteReleaseManifest forgets the release with id
+func DeleteReleaseManifest(domainName string, id string) error {
+	manifestDir, err := releaseManifestDir(domainName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(manifestDir, id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove release manifest: %w", err)
+	}
+	return nil
+}
+
+// SelectRollbackTarget picks the release named id. When id is empty it picks the release before
+// currentId, or before the latest release if currentId is empty or unknown. A current rollback
+// counts as the release it restored, and rollbacks are skipped when stepping back, so repeated
+// rollbacks keep going further back instead of toggling between two releases.
+func SelectRollbackTarget(manifests []*ReleaseManifest, id string, currentId string) (*ReleaseManifest, error) {
+	if id == "" {
+		indexById := make(map[string]int, len(manifests))
+		for index, manifest := range manifests {
+			indexById[manifest.Id] = index
+		}
+		current, ok := indexById[currentId]
+		if !ok {
+			current = len(manifests) - 1
+		}
+		for current >= 0 && manifests[current].RestoredFrom != "" {
+			restored, ok := indexById[manifests[current].RestoredFrom]
+			if !ok || restored >= current {
+				break
+			}
+			current = restored
+		}
+		for index := current - 1; index >= 0; index-- {
+			if manifests[index].RestoredFrom == "" {
+				return manifests[index], nil
+			}
+		}
+		return nil, errors.New("no earlier release to roll back to")
+	}
+	for _, manifest := range manifests {
+		if manifest.Id == id {
+			return manifest, nil
+		}
+	}
+	var ids []string
+	for _, manifest := range manifests {
+		ids = append(ids, manifest.Id)
+	}
+	return nil, fmt.Errorf("unknown release '%s' (known releases: %s)", id, strings.Join(ids, ", "))
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestSelectRollbackTarget(t *testing.T) {
+	releases := []*ReleaseManifest{
+		{Id: "a"},
+		{Id: "b"},
+		{Id: "c"},
+		// Rolling back from c restored b; rolling back again restored a
+		{Id: "d", RestoredFrom: "b"},
+		{Id: "e", RestoredFrom: "a"},
+	}
+	tests := []struct {
+		currentId string
+		want      string
+	}{
+		{"c", "b"},
+		{"d", "a"},
+		// With nothing to go back to from a, e or the latest release
+		{"", ""},
+		{"unknown", ""},
+		{"a", ""},
+		{"b", "a"},
+	}
+	for _, test := range tests {
+		target, err := SelectRollbackTarget(releases, "", test.currentId)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("SelectRollbackTarget(current %q) = %s; want an error", test.currentId, target.Id)
+			}
+			continue
+		}
+		if err != nil || target.Id != test.want {
+			t.Errorf("SelectRollbackTarget(current %q) = %v, %v; want %s", test.currentId, target, err, test.want)
+		}
+	}
+
+	if target, err := SelectRollbackTarget(releases, "c", "e"); err != nil || target.Id != "c" {
+		t.Errorf("SelectRollbackTarget(c) = %v, %v", target, err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// RunReleases implements "hostit releases <domain_name>", which lists the releases that
+// rollback can restore
+func RunReleases(args []string) error {
+	flagSet := flag.NewFlagSet("hostit releases", flag.ContinueOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), "Usage: hostit releases <domain_name>")
+		flagSet.PrintDefaults()
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+		return errors.New("expected a domain name")
+	}
+	domainName := flagSet.Arg(0)
+
+	deploymentState, err := LoadDeploymentState(domainName)
+	if err != nil {
+		return err
+	}
+	if deploymentState == nil {
+		return fmt.Errorf("no deployment of %s is recorded on this machine", domainName)
+	}
+	releases, currentId, err := loadReleases(deploymentState)
+	if err != nil {
+		return err
+	}
+	if len(releases) == 0 {
+		fmt.Printf("No releases of %s are recorded\n", domainName)
+		return nil
+	}
+	for _, release := range releases {
+		marker := " "
+		if release.Id == currentId {
+			marker = "*"
+		}
+		fmt.Printf("%s %s  %s  %s\n", marker, release.Id, release.CreatedAt.Local().Format(time.DateTime), describeRelease(release))
+	}
+	return nil
+}
+
+// loadReleases returns the releases of a deployment, oldest first, and the id of the live one
+func loadReleases(deploymentState *DeploymentState) ([]*ReleaseManifest, string, error) {
+	switch deploymentState.Backend {
+	case "s3":
+		manifests, err := LoadReleaseManifests(deploymentState.Domain)
+		if err != nil {
+			return nil, "", err
+		}
+		currentId := deploymentState.ReleaseId
+		if currentId == "" && len(manifests) > 0 {
+			currentId = manifests[len(manifests)-1].Id
+		}
+		return manifests, currentId, nil
+	case "github":
+		githubObjectStorageProviderManager, err := openGithubDeployment(deploymentState)
+		if err != nil {
+			return nil, "", err
+		}
+		releases, err := githubObjectStorageProviderManager.ListReleases()
+		if err != nil {
+			return nil, "", err
+		}
+		currentId := ""
+		if len(releases) > 0 {
+			currentId = releases[len(releases)-1].Id
+		}
+		return releases, currentId, nil
+	}
+	return nil, "", fmt.Errorf("releases are not recorded for %s deployments", deploymentState.Backend)
+}
+
+func describeRelease(release *ReleaseManifest) string {
+	var description string
+	switch {
+	case release.Prefix != "":
+		description = "atomic, " + release.Prefix
+	case release.Commit != "":
+		description = fmt.Sprintf("%d files, commit %.7s", release.Files, release.Commit)
+	default:
+		description = fmt.Sprintf("%d objects", len(release.Objects))
+	}
+	if release.Source != "" {
+		description += ", from " + release.Source
+	}
+	if release.RestoredFrom != "" {
+		description += ", restored from " + release.RestoredFrom
+	}
+	return description
+}
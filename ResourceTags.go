@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	acmTypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+	cloudfrontTypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// maxResourceTags is the lowest tag limit of the resources hostit tags: S3 buckets, CloudFront
+// distributions and ACM certificates all allow 50
+const maxResourceTags = 50
+
+// ValidateResourceTags applies the AWS tagging rules shared by S3, CloudFront and ACM
+func ValidateResourceTags(tags map[string]string) error {
+	if len(tags) > maxResourceTags {
+		return fmt.Errorf("at most %d tags can be set, got %d", maxResourceTags, len(tags))
+	}
+	for key, value := range tags {
+		switch {
+		case len(key) > 128:
+			return fmt.Errorf("tag key '%s' is longer than 128 characters", key)
+		case len(value) > 256:
+			return fmt.Errorf("value of tag '%s' is longer than 256 characters", key)
+		case strings.HasPrefix(strings.ToLower(key), "aws:"):
+			return fmt.Errorf("tag key '%s' uses the prefix reserved by AWS", key)
+		}
+	}
+	return nil
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func s3Tags(tags map[string]string) []s3Types.Tag {
+	var items []s3Types.Tag
+	for _, key := range sortedTagKeys(tags) {
+		items = append(items, s3Types.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return items
+}
+
+func cloudfrontTags(tags map[string]string) *cloudfrontTypes.Tags {
+	var items []cloudfrontTypes.Tag
+	for _, key := range sortedTagKeys(tags) {
+		items = append(items, cloudfrontTypes.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return &cloudfrontTypes.Tags{Items: items}
+}
+
+func acmTags(tags map[string]string) []acmTypes.Tag {
+	var items []acmTypes.Tag
+	for _, key := range sortedTagKeys(tags) {
+		items = append(items, acmTypes.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return items
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cloudfrontTypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+// SecurityHeadersProfile selects the security response headers CloudFront adds to every response
+type SecurityHeadersProfile string
+
+const (
+	SecurityHeadersNone    SecurityHeadersProfile = "none"
+	SecurityHeadersDefault SecurityHeadersProfile = "default"
+	SecurityHeadersStrict  SecurityHeadersProfile = "strict"
+)
+
+// maxResponseHeadersPolicies is CloudFront's default quota of custom response headers policies
+// per account
+const maxResponseHeadersPolicies = 20
+
+// headerRulesPolicyPrefix names the policies built from a site's _headers file. Their name
+// changes with the rules, so they are deleted once no distribution uses them.
+const headerRulesPolicyPrefix = "hostit-headers-"
+
+// strictContentSecurityPolicy only allows same-origin resources, plus inline styles and data:
+// images which most static site generators emit
+const strictContentSecurityPolicy = "default-src 'self'; img-src 'self' data:; style-src 'self' 'unsafe-inline'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'"
+
+func ParseSecurityHeadersProfile(value string) (SecurityHeadersProfile, error) {
+	switch profile := SecurityHeadersProfile(value); profile {
+	case SecurityHeadersNone, SecurityHeadersDefault, SecurityHeadersStrict:
+		return profile, nil
+	}
+	return "", fmt.Errorf("unknown security headers profile '%s' (expected strict, default or none)", value)
+}
+
+// securityHeadersPolicyConfig builds the response headers policy for profile. A non-empty
+// contentSecurityPolicy replaces the profile's own CSP. It returns nil for the none profile.
+func securityHeadersPolicyConfig(profile SecurityHeadersProfile, contentSecurityPolicy string) *cloudfrontTypes.ResponseHeadersPolicyConfig {
+	var securityHeaders *cloudfrontTypes.ResponseHeadersPolicySecurityHeadersConfig
+	switch profile {
+	case SecurityHeadersDefault:
+		securityHeaders = &cloudfrontTypes.ResponseHeadersPolicySecurityHeadersConfig{
+			StrictTransportSecurity: &cloudfrontTypes.ResponseHeadersPolicyStrictTransportSecurity{
+				AccessControlMaxAgeSec: aws.Int32(31536000),
+				Override:               aws.Bool(true),
+			},
+			ContentTypeOptions: &cloudfrontTypes.ResponseHeadersPolicyContentTypeOptions{
+				Override: aws.Bool(true),
+			},
+			FrameOptions: &cloudfrontTypes.ResponseHeadersPolicyFrameOptions{
+				FrameOption: cloudfrontTypes.FrameOptionsListSameorigin,
+				Override:    aws.Bool(true),
+			},
+			ReferrerPolicy: &cloudfrontTypes.ResponseHeadersPolicyReferrerPolicy{
+				ReferrerPolicy: cloudfrontTypes.ReferrerPolicyListStrictOriginWhenCrossOrigin,
+				Override:       aws.Bool(true),
+			},
+		}
+	case SecurityHeadersStrict:
+		securityHeaders = &cloudfrontTypes.ResponseHeadersPolicySecurityHeadersConfig{
+			StrictTransportSecurity: &cloudfrontTypes.ResponseHeadersPolicyStrictTransportSecurity{
+				AccessControlMaxAgeSec: aws.Int32(63072000),
+				IncludeSubdomains:      aws.Bool(true),
+				Preload:                aws.Bool(true),
+				Override:               aws.Bool(true),
+			},
+			ContentTypeOptions: &cloudfrontTypes.ResponseHeadersPolicyContentTypeOptions{
+				Override: aws.Bool(true),
+			},
+			FrameOptions: &cloudfrontTypes.ResponseHeadersPolicyFrameOptions{
+				FrameOption: cloudfrontTypes.FrameOptionsListDeny,
+				Override:    aws.Bool(true),
+			},
+			ReferrerPolicy: &cloudfrontTypes.ResponseHeadersPolicyReferrerPolicy{
+				ReferrerPolicy: cloudfrontTypes.ReferrerPolicyListNoReferrer,
+				Override:       aws.Bool(true),
+			},
+		}
+		if contentSecurityPolicy == "" {
+			contentSecurityPolicy = strictContentSecurityPolicy
+		}
+	default:
+		return nil
+	}
+	if contentSecurityPolicy != "" {
+		securityHeaders.ContentSecurityPolicy = &cloudfrontTypes.ResponseHeadersPolicyContentSecurityPolicy{
+			ContentSecurityPolicy: aws.String(contentSecurityPolicy),
+			Override:              aws.Bool(true),
+		}
+	}
+
+	// Policies are shared between sites, so the name identifies the exact configuration
+	name := "hostit-security-" + string(profile)
+	if contentSecurityPolicy != "" {
+		digest := sha256.Sum256([]byte(contentSecurityPolicy))
+		name += "-" + hex.EncodeToString(digest[:])[:8]
+	}
+	return &cloudfrontTypes.ResponseHeadersPolicyConfig{
+		Name:                  aws.String(name),
+		Comment:               aws.String(fmt.Sprintf("Hostit %s security headers", profile)),
+		SecurityHeadersConfig: securityHeaders,
+	}
+}
+
+// ensureResponseHeadersPolicy returns the id of the custom response headers policy named in
+// policyConfig, creating it when missing and bringing an existing one up to date.
+func ensureResponseHeadersPolicy(ctx context.Context, cloudfrontClient *cloudfront.Client, policyConfig *cloudfrontTypes.ResponseHeadersPolicyConfig) (string, error) {
+	name := aws.ToString(policyConfig.Name)
+	policyCount := 0
+	var marker *string
+	for {
+		listOut, err := cloudfrontClient.ListResponseHeadersPolicies(ctx, &cloudfront.ListResponseHeadersPoliciesInput{
+			Type:   cloudfrontTypes.ResponseHeadersPolicyTypeCustom,
+			Marker: marker,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed listing response headers policies: %w", err)
+		}
+		if listOut.ResponseHeadersPolicyList == nil {
+			break
+		}
+		for _, summary := range listOut.ResponseHeadersPolicyList.Items {
+			if summary.ResponseHeadersPolicy == nil || summary.ResponseHeadersPolicy.ResponseHeadersPolicyConfig == nil {
+				continue
+			}
+			policyCount++
+			if aws.ToString(summary.ResponseHeadersPolicy.ResponseHeadersPolicyConfig.Name) != name {
+				continue
+			}
+			policyId := summary.ResponseHeadersPolicy.Id
+			getOut, err := cloudfrontClient.GetResponseHeadersPolicyConfig(ctx, &cloudfront.GetResponseHeadersPolicyConfigInput{Id: policyId})
+			if err != nil {
+				return "", fmt.Errorf("failed reading response headers policy '%s': %w", name, err)
+			}
+			_, err = cloudfrontClient.UpdateResponseHeadersPolicy(ctx, &cloudfront.UpdateResponseHeadersPolicyInput{
+				Id:                          policyId,
+				IfMatch:                     getOut.ETag,
+				ResponseHeadersPolicyConfig: policyConfig,
+			})
+			if err != nil {
+				return "", fmt.Errorf("failed updating response headers policy '%s': %w", name, err)
+			}
+			return aws.ToString(policyId), nil
+		}
+		if listOut.ResponseHeadersPolicyList.NextMarker == nil || *listOut.ResponseHeadersPolicyList.NextMarker == "" {
+			break
+		}
+		marker = listOut.ResponseHeadersPolicyList.NextMarker
+	}
+
+	if policyCount >= maxResponseHeadersPolicies {
+		return "", fmt.Errorf("cannot create response headers policy '%s': the account already has %d custom response headers policies, the most CloudFront allows by default; delete unused %s* policies, merge _headers rules or request a quota increase", name, policyCount, headerRulesPolicyPrefix)
+	}
+	createOut, err := cloudfrontClient.CreateResponseHeadersPolicy(ctx, &cloudfront.CreateResponseHeadersPolicyInput{
+		ResponseHeadersPolicyConfig: policyConfig,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed creating response headers policy '%s': %w", name, err)
+	}
+	if createOut.ResponseHeadersPolicy == nil || createOut.ResponseHeadersPolicy.Id == nil {
+		return "", fmt.Errorf("unexpected empty response headers policy response for '%s'", name)
+	}
+	return *createOut.ResponseHeadersPolicy.Id, nil
+}
+
+// headerRulesPolicyConfig extends the security headers policy for profile with headers from a
+// _headers file. Security and CORS headers go into the policy's dedicated settings, since
+// CloudFront rejects them as custom headers; headers it cannot express are returned as warnings.
+func headerRulesPolicyConfig(profile SecurityHeadersProfile, contentSecurityPolicy string, headers []HeaderField) (*cloudfrontTypes.ResponseHeadersPolicyConfig, []string) {
+	policyConfig := securityHeadersPolicyConfig(profile, contentSecurityPolicy)
+	if len(headers) == 0 {
+		return policyConfig, nil
+	}
+	baseName := "none"
+	if policyConfig == nil {
+		policyConfig = &cloudfrontTypes.ResponseHeadersPolicyConfig{}
+	} else {
+		baseName = aws.ToString(policyConfig.Name)
+	}
+	securityHeaders := policyConfig.SecurityHeadersConfig
+	if securityHeaders == nil {
+		securityHeaders = &cloudfrontTypes.ResponseHeadersPolicySecurityHeadersConfig{}
+	}
+	corsHeaders := map[string]string{}
+	var customHeaders []cloudfrontTypes.ResponseHeadersPolicyCustomHeader
+	var warnings []string
+	digest := sha256.New()
+	digest.Write([]byte(baseName))
+
+	for _, header := range headers {
+		fmt.Fprintf(digest, "\n%s: %s", header.Name, header.Value)
+		canonicalName := strings.ToLower(header.Name)
+		switch {
+		case canonicalName == "content-security-policy":
+			securityHeaders.ContentSecurityPolicy = &cloudfrontTypes.ResponseHeadersPolicyContentSecurityPolicy{
+				ContentSecurityPolicy: aws.String(header.Value),
+				Override:              aws.Bool(true),
+			}
+		case canonicalName == "x-content-type-options" && strings.EqualFold(header.Value, "nosniff"):
+			securityHeaders.ContentTypeOptions = &cloudfrontTypes.ResponseHeadersPolicyContentTypeOptions{Override: aws.Bool(true)}
+		case canonicalName == "x-frame-options" && (strings.EqualFold(header.Value, "DENY") || strings.EqualFold(header.Value, "SAMEORIGIN")):
+			securityHeaders.FrameOptions = &cloudfrontTypes.ResponseHeadersPolicyFrameOptions{
+				FrameOption: cloudfrontTypes.FrameOptionsList(strings.ToUpper(header.Value)),
+				Override:    aws.Bool(true),
+			}
+		case canonicalName == "referrer-policy" && slices.Contains(cloudfrontTypes.ReferrerPolicyList("").Values(), cloudfrontTypes.ReferrerPolicyList(strings.ToLower(header.Value))):
+			securityHeaders.ReferrerPolicy = &cloudfrontTypes.ResponseHeadersPolicyReferrerPolicy{
+				ReferrerPolicy: cloudfrontTypes.ReferrerPolicyList(strings.ToLower(header.Value)),
+				Override:       aws.Bool(true),
+			}
+		case canonicalName == "strict-transport-security":
+			strictTransportSecurity, err := parseStrictTransportSecurity(header.Value)
+			if err != nil {
+				warnings = append(warnings, err.Error())
+				continue
+			}
+			securityHeaders.StrictTransportSecurity = strictTransportSecurity
+		case strings.HasPrefix(canonicalName, "access-control-"):
+			corsHeaders[canonicalName] = header.Value
+		case canonicalName == "cache-control":
+			warnings = append(warnings, "Cache-Control is ignored; use -cache-control so CloudFront caches for the same time")
+		case canonicalName == "x-frame-options" || canonicalName == "x-content-type-options" || canonicalName == "referrer-policy" || canonicalName == "x-xss-protection":
+			warnings = append(warnings, fmt.Sprintf("CloudFront cannot send %s: %s", header.Name, header.Value))
+		default:
+			customHeaders = append(customHeaders, cloudfrontTypes.ResponseHeadersPolicyCustomHeader{
+				Header:   aws.String(header.Name),
+				Value:    aws.String(header.Value),
+				Override: aws.Bool(true),
+			})
+		}
+	}
+
+	if *securityHeaders != (cloudfrontTypes.ResponseHeadersPolicySecurityHeadersConfig{}) {
+		policyConfig.SecurityHeadersConfig = securityHeaders
+	}
+	if len(customHeaders) > 0 {
+		policyConfig.CustomHeadersConfig = &cloudfrontTypes.ResponseHeadersPolicyCustomHeadersConfig{
+			Quantity: aws.Int32(int32(len(customHeaders))),
+			Items:    customHeaders,
+		}
+	}
+	if len(corsHeaders) > 0 {
+		corsConfig, err := corsPolicyConfig(corsHeaders)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+		} else {
+			policyConfig.CorsConfig = corsConfig
+		}
+	}
+	if policyConfig.SecurityHeadersConfig == nil && policyConfig.CustomHeadersConfig == nil && policyConfig.CorsConfig == nil {
+		return nil, warnings
+	}
+	policyConfig.Name = aws.String(headerRulesPolicyPrefix + hex.EncodeToString(digest.Sum(nil))[:16])
+	policyConfig.Comment = aws.String("Hostit _headers rules")
+	return policyConfig, warnings
+}
+
+// distributionResponseHeadersPolicyIds collects the response headers policies attached to the
+// cache behaviors of distributionConfig
+func distributionResponseHeadersPolicyIds(distributionConfig *cloudfrontTypes.DistributionConfig) Set[string] {
+	policyIds := NewSet[string]()
+	if distributionConfig.DefaultCacheBehavior != nil && aws.ToString(distributionConfig.DefaultCacheBehavior.ResponseHeadersPolicyId) != "" {
+		policyIds.Add(aws.ToString(distributionConfig.DefaultCacheBehavior.ResponseHeadersPolicyId))
+	}
+	if distributionConfig.CacheBehaviors != nil {
+		for _, behavior := range distributionConfig.CacheBehaviors.Items {
+			if aws.ToString(behavior.ResponseHeadersPolicyId) != "" {
+				policyIds.Add(aws.ToString(behavior.ResponseHeadersPolicyId))
+			}
+		}
+	}
+	return policyIds
+}
+
+// deleteUnusedHeaderRulesPolicies deletes the _headers policies among policyIds that no
+// distribution uses any more. Shared security headers policies are kept, and failures only
+// warn since the deploy itself succeeded.
+func deleteUnusedHeaderRulesPolicies(ctx context.Context, cloudfrontClient *cloudfront.Client, policyIds Set[string]) {
+	for policyId := range policyIds {
+		getOut, err := cloudfrontClient.GetResponseHeadersPolicy(ctx, &cloudfront.GetResponseHeadersPolicyInput{Id: aws.String(policyId)})
+		if err != nil {
+			fmt.Printf("Warning: failed reading response headers policy %s: %s\n", policyId, err)
+			continue
+		}
+		if getOut.ResponseHeadersPolicy == nil || getOut.ResponseHeadersPolicy.ResponseHeadersPolicyConfig == nil {
+			continue
+		}
+		name := aws.ToString(getOut.ResponseHeadersPolicy.ResponseHeadersPolicyConfig.Name)
+		if !strings.HasPrefix(name, headerRulesPolicyPrefix) {
+			continue
+		}
+		listOut, err := cloudfrontClient.ListDistributionsByResponseHeadersPolicyId(ctx, &cloudfront.ListDistributionsByResponseHeadersPolicyIdInput{
+			ResponseHeadersPolicyId: aws.String(policyId),
+		})
+		if err != nil {
+			fmt.Printf("Warning: failed listing distributions using response headers policy '%s': %s\n", name, err)
+			continue
+		}
+		if listOut.DistributionIdList != nil && aws.ToInt32(listOut.DistributionIdList.Quantity) > 0 {
+			continue
+		}
+		_, err = cloudfrontClient.DeleteResponseHeadersPolicy(ctx, &cloudfront.DeleteResponseHeadersPolicyInput{
+			Id:      aws.String(policyId),
+			IfMatch: getOut.ETag,
+		})
+		if err != nil {
+			fmt.Printf("Warning: failed deleting unused response headers policy '%s': %s\n", name, err)
+			continue
+		}
+		fmt.Printf("Deleted unused response headers policy '%s'\n", name)
+	}
+}
+
+// parseStrictTransportSecurity reads "max-age=<seconds>[; includeSubDomains][; preload]"
+func parseStrictTransportSecurity(value string) (*cloudfrontTypes.ResponseHeadersPolicyStrictTransportSecurity, error) {
+	strictTransportSecurity := &cloudfrontTypes.ResponseHeadersPolicyStrictTransportSecurity{Override: aws.Bool(true)}
+	for _, directive := range strings.Split(value, ";") {
+		directive = strings.TrimSpace(directive)
+		key, val, _ := strings.Cut(directive, "=")
+		switch strings.ToLower(key) {
+		case "max-age":
+			maxAge, err := strconv.ParseInt(val, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("CloudFront cannot send Strict-Transport-Security: invalid max-age '%s'", val)
+			}
+			strictTransportSecurity.AccessControlMaxAgeSec = aws.Int32(int32(maxAge))
+		case "includesubdomains":
+			strictTransportSecurity.IncludeSubdomains = aws.Bool(true)
+		case "preload":
+			strictTransportSecurity.Preload = aws.Bool(true)
+		case "":
+		default:
+			return nil, fmt.Errorf("CloudFront cannot send Strict-Transport-Security: unknown directive '%s'", directive)
+		}
+	}
+	if strictTransportSecurity.AccessControlMaxAgeSec == nil {
+		return nil, errors.New("CloudFront cannot send Strict-Transport-Security without max-age")
+	}
+	return strictTransportSecurity, nil
+}
+
+// corsPolicyConfig builds CloudFront's CORS settings from Access-Control-* headers, keyed by
+// lower-case name. Access-Control-Allow-Origin is required; methods default to GET and HEAD.
+func corsPolicyConfig(corsHeaders map[string]string) (*cloudfrontTypes.ResponseHeadersPolicyCorsConfig, error) {
+	splitList := func(value string) []string {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	origins := splitList(corsHeaders["access-control-allow-origin"])
+	if len(origins) == 0 {
+		return nil, errors.New("CloudFront cannot send Access-Control-* headers without Access-Control-Allow-Origin")
+	}
+	allowHeaders := splitList(corsHeaders["access-control-allow-headers"])
+	if len(allowHeaders) == 0 {
+		allowHeaders = []string{"*"}
+	}
+	var methods []cloudfrontTypes.ResponseHeadersPolicyAccessControlAllowMethodsValues
+	for _, method := range splitList(corsHeaders["access-control-allow-methods"]) {
+		value := cloudfrontTypes.ResponseHeadersPolicyAccessControlAllowMethodsValues(strings.ToUpper(method))
+		if !slices.Contains(value.Values(), value) {
+			return nil, fmt.Errorf("CloudFront cannot send Access-Control-Allow-Methods: unknown method '%s'", method)
+		}
+		methods = append(methods, value)
+	}
+	if len(methods) == 0 {
+		methods = []cloudfrontTypes.ResponseHeadersPolicyAccessControlAllowMethodsValues{
+			cloudfrontTypes.ResponseHeadersPolicyAccessControlAllowMethodsValuesGet,
+			cloudfrontTypes.ResponseHeadersPolicyAccessControlAllowMethodsValuesHead,
+		}
+	}
+	corsConfig := &cloudfrontTypes.ResponseHeadersPolicyCorsConfig{
+		AccessControlAllowCredentials: aws.Bool(strings.EqualFold(corsHeaders["access-control-allow-credentials"], "true")),
+		AccessControlAllowHeaders: &cloudfrontTypes.ResponseHeadersPolicyAccessControlAllowHeaders{
+			Quantity: aws.Int32(int32(len(allowHeaders))),
+			Items:    allowHeaders,
+		},
+		AccessControlAllowMethods: &cloudfrontTypes.ResponseHeadersPolicyAccessControlAllowMethods{
+			Quantity: aws.Int32(int32(len(methods))),
+			Items:    methods,
+		},
+		AccessControlAllowOrigins: &cloudfrontTypes.ResponseHeadersPolicyAccessControlAllowOrigins{
+			Quantity: aws.Int32(int32(len(origins))),
+			Items:    origins,
+		},
+		OriginOverride: aws.Bool(true),
+	}
+	if exposeHeaders := splitList(corsHeaders["access-control-expose-headers"]); len(exposeHeaders) > 0 {
+		corsConfig.AccessControlExposeHeaders = &cloudfrontTypes.ResponseHeadersPolicyAccessControlExposeHeaders{
+			Quantity: aws.Int32(int32(len(exposeHeaders))),
+			Items:    exposeHeaders,
+		}
+	}
+	if maxAge := corsHeaders["access-control-max-age"]; maxAge != "" {
+		seconds, err := strconv.ParseInt(maxAge, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("CloudFront cannot send Access-Control-Max-Age: invalid value '%s'", maxAge)
+		}
+		corsConfig.AccessControlMaxAgeSec = aws.Int32(int32(seconds))
+	}
+	return corsConfig, nil
+}
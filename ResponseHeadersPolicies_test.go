@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cloudfrontTypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+)
+
+func TestHeaderRulesPolicyConfig(t *testing.T) {
+	policyConfig, warnings := headerRulesPolicyConfig(SecurityHeadersNone, "", []HeaderField{
+		{"Content-Security-Policy", "frame-ancestors *"},
+		{"X-Frame-Options", "sameorigin"},
+		{"X-Content-Type-Options", "nosniff"},
+		{"Referrer-Policy", "No-Referrer"},
+		{"Strict-Transport-Security", "max-age=600; includeSubDomains"},
+		{"Access-Control-Allow-Origin", "https://a.example, https://b.example"},
+		{"Access-Control-Allow-Methods", "get, post"},
+		{"X-Robots-Tag", "noindex"},
+		{"Cache-Control", "no-cache"},
+		{"X-XSS-Protection", "1; mode=block"},
+	})
+	if policyConfig == nil {
+		t.Fatal("no policy")
+	}
+	if name := aws.ToString(policyConfig.Name); !strings.HasPrefix(name, headerRulesPolicyPrefix) || len(name) != len(headerRulesPolicyPrefix)+16 {
+		t.Errorf("name = %s", name)
+	}
+
+	securityHeaders := policyConfig.SecurityHeadersConfig
+	switch {
+	case securityHeaders == nil:
+		t.Fatal("security headers missing")
+	case aws.ToString(securityHeaders.ContentSecurityPolicy.ContentSecurityPolicy) != "frame-ancestors *":
+		t.Errorf("CSP = %v", securityHeaders.ContentSecurityPolicy)
+	case securityHeaders.FrameOptions.FrameOption != cloudfrontTypes.FrameOptionsListSameorigin:
+		t.Errorf("frame options = %v", securityHeaders.FrameOptions)
+	case securityHeaders.ContentTypeOptions == nil:
+		t.Error("content type options missing")
+	case securityHeaders.ReferrerPolicy.ReferrerPolicy != cloudfrontTypes.ReferrerPolicyListNoReferrer:
+		t.Errorf("referrer policy = %v", securityHeaders.ReferrerPolicy)
+	case aws.ToInt32(securityHeaders.StrictTransportSecurity.AccessControlMaxAgeSec) != 600 || !aws.ToBool(securityHeaders.StrictTransportSecurity.IncludeSubdomains):
+		t.Errorf("HSTS = %+v", securityHeaders.StrictTransportSecurity)
+	}
+
+	corsConfig := policyConfig.CorsConfig
+	if corsConfig == nil || len(corsConfig.AccessControlAllowOrigins.Items) != 2 || len(corsConfig.AccessControlAllowMethods.Items) != 2 || corsConfig.AccessControlAllowHeaders.Items[0] != "*" {
+		t.Errorf("CORS = %+v", corsConfig)
+	}
+	customHeaders := policyConfig.CustomHeadersConfig
+	if customHeaders == nil || len(customHeaders.Items) != 1 || aws.ToString(customHeaders.Items[0].Header) != "X-Robots-Tag" {
+		t.Errorf("custom headers = %+v", customHeaders)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "Cache-Control") || !strings.Contains(warnings[1], "X-XSS-Protection") {
+		t.Errorf("warnings = %v", warnings)
+	}
+}
+
+func TestHeaderRulesPolicyConfigWithoutHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  SecurityHeadersProfile
+		headers  []HeaderField
+		wantName string
+	}{
+		// The shared security headers policy serves paths without rules of their own
+		{"profile only", SecurityHeadersDefault, nil, "hostit-security-default"},
+		{"no profile and no rules", SecurityHeadersNone, nil, ""},
+		// Nothing CloudFront can send leaves no policy at all
+		{"unsupported headers only", SecurityHeadersNone, []HeaderField{{"Cache-Control", "no-cache"}}, ""},
+	}
+	for _, test := range tests {
+		policyConfig, _ := headerRulesPolicyConfig(test.profile, "", test.headers)
+		name := ""
+		if policyConfig != nil {
+			name = aws.ToString(policyConfig.Name)
+		}
+		if name != test.wantName {
+			t.Errorf("%s: policy = %q; want %q", test.name, name, test.wantName)
+		}
+	}
+}
+
+func TestHeaderRulesPolicyConfigIsStable(t *testing.T) {
+	headers := []HeaderField{{"X-Robots-Tag", "noindex"}}
+	first, _ := headerRulesPolicyConfig(SecurityHeadersStrict, "", headers)
+	second, _ := headerRulesPolicyConfig(SecurityHeadersStrict, "", headers)
+	other, _ := headerRulesPolicyConfig(SecurityHeadersDefault, "", headers)
+	if aws.ToString(first.Name) != aws.ToString(second.Name) {
+		t.Error("the same rules got different policy names")
+	}
+	if aws.ToString(first.Name) == aws.ToString(other.Name) {
+		t.Error("a different profile reused the policy name")
+	}
+}
+
+func TestCorsPolicyConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		wantErr string
+	}{
+		{"origin required", map[string]string{"access-control-allow-methods": "GET"}, "without Access-Control-Allow-Origin"},
+		{"unknown method", map[string]string{"access-control-allow-origin": "*", "access-control-allow-methods": "FETCH"}, "unknown method 'FETCH'"},
+		{"invalid max age", map[string]string{"access-control-allow-origin": "*", "access-control-max-age": "soon"}, "invalid value 'soon'"},
+		{"defaults", map[string]string{"access-control-allow-origin": "*"}, ""},
+	}
+	for _, test := range tests {
+		corsConfig, err := corsPolicyConfig(test.headers)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error = %v; want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || len(corsConfig.AccessControlAllowMethods.Items) != 2 || aws.ToBool(corsConfig.AccessControlAllowCredentials) {
+			t.Errorf("%s: %+v, %v", test.name, corsConfig, err)
+		}
+	}
+}
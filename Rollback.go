@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// RunRollback implements "hostit rollback [options] <domain_name>", which makes an earlier
+// recorded release live again
+func RunRollback(args []string) error {
+	flagSet := flag.NewFlagSet("hostit rollback", flag.ContinueOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), "Usage: hostit rollback [options] <domain_name>")
+		flagSet.PrintDefaults()
+	}
+	to := flagSet.String("to", "", "release to restore (default: the release before the latest)")
+	waitForInvalidation := flagSet.Bool("wait-invalidation", false, "wait for the CloudFront invalidation to complete")
+	waitForBuild := flagSet.Bool("wait-build", true, "wait for the GitHub Pages build to finish")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+		return errors.New("expected a domain name")
+	}
+	domainName := flagSet.Arg(0)
+
+	deploymentState, err := LoadDeploymentState(domainName)
+	if err != nil {
+		return err
+	}
+	if deploymentState == nil {
+		return fmt.Errorf("no deployment of %s is recorded on this machine", domainName)
+	}
+	releases, currentId, err := loadReleases(deploymentState)
+	if err != nil {
+		return err
+	}
+	target, err := SelectRollbackTarget(releases, *to, currentId)
+	if err != nil {
+		return err
+	}
+
+	switch deploymentState.Backend {
+	case "s3":
+		// Start from the deploy defaults so invalidation behaves as it does after a deploy
+		options, _, err := ParseDeployOptions(nil)
+		if err != nil {
+			return err
+		}
+		options.WaitForInvalidation = *waitForInvalidation
+		s3ObjectStorageProviderManager, err := NewS3ObjectStorageProviderManager(domainName, "", options)
+		if err != nil {
+			return err
+		}
+		if err := s3ObjectStorageProviderManager.InstantiateClient(); err != nil {
+			return err
+		}
+		if err := s3ObjectStorageProviderManager.RestoreRelease(target); err != nil {
+			return err
+		}
+		return s3ObjectStorageProviderManager.InvalidateCache()
+	case "github":
+		githubObjectStorageProviderManager, err := openGithubDeployment(deploymentState)
+		if err != nil {
+			return err
+		}
+		commitSha, err := githubObjectStorageProviderManager.RestoreRelease(target)
+		if err != nil {
+			return err
+		}
+		if !*waitForBuild {
+			return nil
+		}
+		return githubObjectStorageProviderManager.WaitForPagesBuild(commitSha)
+	}
+	return fmt.Errorf("rollback is not supported for %s deployments", deploymentState.Backend)
+}
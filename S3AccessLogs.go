@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ensureLogBucket prepares the bucket CloudFront writes standard logs to. Standard logging
+// delivers files through an ACL grant, so the bucket keeps ACLs enabled with BucketOwnerPreferred
+// ownership; the site bucket itself stays ACL-free.
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) ensureLogBucket(ctx context.Context) error {
+	if !s3ObjectStorageProviderManager.options.AccessLogs {
+		return nil
+	}
+	logBucket := s3ObjectStorageProviderManager.options.AccessLogBucket
+	if logBucket == "" && s3ObjectStorageProviderManager.deploymentState != nil {
+		logBucket = s3ObjectStorageProviderManager.deploymentState.LogBucket
+	}
+	if logBucket == "" {
+		logBucket = DefaultBucketName(s3ObjectStorageProviderManager.awsAccountNumber, "logs."+s3ObjectStorageProviderManager.domainName)
+	}
+	logPrefix := s3ObjectStorageProviderManager.options.AccessLogPrefix
+	if logPrefix == "" {
+		logPrefix = s3ObjectStorageProviderManager.domainName + "/"
+	}
+	s3Client := s3ObjectStorageProviderManager.s3Client
+	// Settings of an existing log bucket go through a client for its own region
+	logBucketManager := *s3ObjectStorageProviderManager
+
+	bucketRegion, err := manager.GetBucketRegion(ctx, s3Client, logBucket)
+	var bucketNotFound manager.BucketNotFound
+	switch {
+	case errors.As(err, &bucketNotFound):
+		if err := ValidateNewBucketName(logBucket); err != nil {
+			return err
+		}
+		createBucketInput := &s3.CreateBucketInput{
+			Bucket:          aws.String(logBucket),
+			ObjectOwnership: s3Types.ObjectOwnershipBucketOwnerPreferred,
+		}
+		if s3ObjectStorageProviderManager.region != "us-east-1" {
+			createBucketInput.CreateBucketConfiguration = &s3Types.CreateBucketConfiguration{
+				LocationConstraint: s3Types.BucketLocationConstraint(s3ObjectStorageProviderManager.region),
+			}
+		}
+		if _, err := s3Client.CreateBucket(ctx, createBucketInput); err != nil {
+			return fmt.Errorf("issue with creating log bucket %s in %s: %w", logBucket, s3ObjectStorageProviderManager.region, err)
+		}
+		// Blocking public ACLs still allows the grant to CloudFront's log delivery account
+		_, err = s3Client.PutPublicAccessBlock(ctx, &s3.PutPublicAccessBlockInput{
+			Bucket: aws.String(logBucket),
+			PublicAccessBlockConfiguration: &s3Types.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(true),
+				IgnorePublicAcls:      aws.Bool(true),
+				BlockPublicPolicy:     aws.Bool(true),
+				RestrictPublicBuckets: aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("issue with setting security policy in log bucket: %w", err)
+		}
+		fmt.Printf("Created log bucket %s\n", logBucket)
+	case err != nil:
+		return fmt.Errorf("failed looking up log bucket %s: %w", logBucket, err)
+	default:
+		// CloudFront delivers standard logs to a bucket in any region
+		if bucketRegion != s3ObjectStorageProviderManager.region {
+			logBucketManager.s3Client = s3.New(s3Client.Options(), func(o *s3.Options) {
+				o.Region = bucketRegion
+			})
+			s3Client = logBucketManager.s3Client
+		}
+		_, err = s3Client.HeadBucket(ctx, &s3.HeadBucketInput{
+			Bucket:              aws.String(logBucket),
+			ExpectedBucketOwner: aws.String(s3ObjectStorageProviderManager.awsAccountNumber),
+		})
+		var responseError *awshttp.ResponseError
+		if errors.As(err, &responseError) && responseError.HTTPStatusCode() == 403 {
+			return fmt.Errorf("log bucket %s exists but is not owned by account %s", logBucket, s3ObjectStorageProviderManager.awsAccountNumber)
+		}
+		if err != nil {
+			return fmt.Errorf("failed checking log bucket %s: %w", logBucket, err)
+		}
+		_, err = s3Client.PutBucketOwnershipControls(ctx, &s3.PutBucketOwnershipControlsInput{
+			Bucket: aws.String(logBucket),
+			OwnershipControls: &s3Types.OwnershipControls{
+				Rules: []s3Types.OwnershipControlsRule{
+					{ObjectOwnership: s3Types.ObjectOwnershipBucketOwnerPreferred},
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to enable ACLs on log bucket %s: %w", logBucket, err)
+		}
+	}
+
+	if err := logBucketManager.expireLogs(ctx, logBucket, logPrefix); err != nil {
+		return err
+	}
+	if err := logBucketManager.tagBucket(ctx, logBucket); err != nil {
+		return err
+	}
+	s3ObjectStorageProviderManager.logBucket = logBucket
+	s3ObjectStorageProviderManager.logPrefix = logPrefix
+	fmt.Printf("CloudFront logs go to s3://%s/%s\n", logBucket, logPrefix)
+	return nil
+}
+
+// expireLogs keeps a lifecycle rule that deletes the logs under logPrefix after the retention
+// period. Rules for other prefixes are kept, so a log bucket can be shared between sites.
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) expireLogs(ctx context.Context, logBucket string, logPrefix string) error {
+	var rule *s3Types.LifecycleRule
+	if retentionDays := s3ObjectStorageProviderManager.options.AccessLogRetentionDays; retentionDays > 0 {
+		rule = &s3Types.LifecycleRule{
+			Status:     s3Types.ExpirationStatusEnabled,
+			Filter:     &s3Types.LifecycleRuleFilter{Prefix: aws.String(logPrefix)},
+			Expiration: &s3Types.LifecycleExpiration{Days: aws.Int32(int32(retentionDays))},
+		}
+	}
+	return s3ObjectStorageProviderManager.putLifecycleRule(ctx, logBucket, "hostit-expire-"+logPrefix, rule)
+}
+
+// logBucketDomainName is the S3 domain CloudFront's logging config expects for logBucket
+func logBucketDomainName(logBucket string) string {
+	if logBucket == "" {
+		return ""
+	}
+	return logBucket + ".s3.amazonaws.com"
+}
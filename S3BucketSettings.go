@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// BucketEncryption is the default encryption hostit sets on the S3 bucket
+type BucketEncryption string
+
+const (
+	// BucketEncryptionUnchanged leaves the bucket's setting alone; new buckets use SSE-S3
+	BucketEncryptionUnchanged BucketEncryption = ""
+	BucketEncryptionS3        BucketEncryption = "sse-s3"
+	BucketEncryptionKms       BucketEncryption = "sse-kms"
+)
+
+func ParseBucketEncryption(value string, kmsKeyId string) (BucketEncryption, error) {
+	switch BucketEncryption(value) {
+	case BucketEncryptionUnchanged:
+		if kmsKeyId != "" {
+			return BucketEncryptionKms, nil
+		}
+		return BucketEncryptionUnchanged, nil
+	case BucketEncryptionS3:
+		if kmsKeyId != "" {
+			return "", errors.New("-kms-key-id cannot be combined with -encryption sse-s3")
+		}
+		return BucketEncryptionS3, nil
+	case BucketEncryptionKms:
+		// The AWS managed aws/s3 key's policy cannot grant CloudFront access
+		if kmsKeyId == "" {
+			return "", errors.New("-encryption sse-kms requires -kms-key-id, since CloudFront cannot decrypt objects encrypted with the AWS managed key")
+		}
+		return BucketEncryptionKms, nil
+	}
+	return "", fmt.Errorf("unknown encryption '%s' (expected sse-s3 or sse-kms)", value)
+}
+
+// configureBucket applies the bucket settings hostit manages. It runs on every deploy so changed
+// options reach buckets created earlier.
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) configureBucket(ctx context.Context, bucketName string) error {
+	if err := s3ObjectStorageProviderManager.enableVersioning(ctx, bucketName); err != nil {
+		return err
+	}
+	if err := s3ObjectStorageProviderManager.expireOldVersions(ctx, bucketName); err != nil {
+		return err
+	}
+	if err := s3ObjectStorageProviderManager.putBucketEncryption(ctx, bucketName); err != nil {
+		return err
+	}
+	return s3ObjectStorageProviderManager.tagBucket(ctx, bucketName)
+}
+
+// expireOldVersions lets S3 delete object versions that no kept release serves. Each noncurrent
+// version of a key was replaced by a later release, so keeping the newest KeepReleases-1 of them
+// keeps every version the last KeepReleases releases need.
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) expireOldVersions(ctx context.Context, bucketName string) error {
+	return s3ObjectStorageProviderManager.putLifecycleRule(ctx, bucketName, "hostit-expire-old-versions", &s3Types.LifecycleRule{
+		Status: s3Types.ExpirationStatusEnabled,
+		Filter: &s3Types.LifecycleRuleFilter{Prefix: aws.String("")},
+		NoncurrentVersionExpiration: &s3Types.NoncurrentVersionExpiration{
+			NoncurrentDays:          aws.Int32(1),
+			NewerNoncurrentVersions: aws.Int32(int32(max(s3ObjectStorageProviderManager.options.KeepReleases-1, 1))),
+		},
+		// Delete markers left once every version of a removed file has expired
+		Expiration: &s3Types.LifecycleExpiration{ExpiredObjectDeleteMarker: aws.Bool(true)},
+	})
+}
+
+// putLifecycleRule replaces the bucket's lifecycle rule named ruleId with rule, or removes it
+// when rule is nil. Rules hostit did not write are kept.
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) putLifecycleRule(ctx context.Context, bucketName string, ruleId string, rule *s3Types.LifecycleRule) error {
+	s3Client := s3ObjectStorageProviderManager.s3Client
+	var rules []s3Types.LifecycleRule
+	getOut, err := s3Client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucketName),
+	})
+	var responseError *awshttp.ResponseError
+	switch {
+	case err == nil:
+		rules = getOut.Rules
+	case errors.As(err, &responseError) && responseError.HTTPStatusCode() == 404:
+		// NoSuchLifecycleConfiguration: the bucket has no rules yet
+	default:
+		return fmt.Errorf("failed to read lifecycle rules of bucket %s: %w", bucketName, err)
+	}
+	rules = slices.DeleteFunc(rules, func(existing s3Types.LifecycleRule) bool {
+		return aws.ToString(existing.ID) == ruleId
+	})
+	if rule != nil {
+		rule.ID = aws.String(ruleId)
+		rules = append(rules, *rule)
+	}
+
+	if len(rules) == 0 {
+		_, err = s3Client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{
+			Bucket: aws.String(bucketName),
+		})
+	} else {
+		_, err = s3Client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+			Bucket:                 aws.String(bucketName),
+			LifecycleConfiguration: &s3Types.BucketLifecycleConfiguration{Rules: rules},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set lifecycle rules of bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// putBucketEncryption sets the requested default encryption when the bucket uses another one.
+// Objects keep the encryption they were written with until they are uploaded again.
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) putBucketEncryption(ctx context.Context, bucketName string) error {
+	var encryptionDefault s3Types.ServerSideEncryptionByDefault
+	switch s3ObjectStorageProviderManager.options.BucketEncryption {
+	case BucketEncryptionUnchanged:
+		return nil
+	case BucketEncryptionS3:
+		encryptionDefault.SSEAlgorithm = s3Types.ServerSideEncryptionAes256
+	case BucketEncryptionKms:
+		encryptionDefault.SSEAlgorithm = s3Types.ServerSideEncryptionAwsKms
+		encryptionDefault.KMSMasterKeyID = aws.String(s3ObjectStorageProviderManager.options.KmsKeyId)
+	}
+
+	getOut, err := s3ObjectStorageProviderManager.s3Client.GetBucketEncryption(ctx, &s3.GetBucketEncryptionInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read encryption of bucket %s: %w", bucketName, err)
+	}
+	if getOut.ServerSideEncryptionConfiguration != nil && len(getOut.ServerSideEncryptionConfiguration.Rules) == 1 {
+		current := getOut.ServerSideEncryptionConfiguration.Rules[0].ApplyServerSideEncryptionByDefault
+		if current != nil && current.SSEAlgorithm == encryptionDefault.SSEAlgorithm && aws.ToString(current.KMSMasterKeyID) == aws.ToString(encryptionDefault.KMSMasterKeyID) {
+			return nil
+		}
+	}
+
+	_, err = s3ObjectStorageProviderManager.s3Client.PutBucketEncryption(ctx, &s3.PutBucketEncryptionInput{
+		Bucket: aws.String(bucketName),
+		ServerSideEncryptionConfiguration: &s3Types.ServerSideEncryptionConfiguration{
+			Rules: []s3Types.ServerSideEncryptionRule{
+				{
+					ApplyServerSideEncryptionByDefault: &encryptionDefault,
+					// Bucket keys cut the KMS requests, and cost, of reading many small objects
+					BucketKeyEnabled: aws.Bool(encryptionDefault.SSEAlgorithm == s3Types.ServerSideEncryptionAwsKms),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set encryption on bucket %s: %w", bucketName, err)
+	}
+	fmt.Printf("Bucket %s now encrypts new objects with %s\n", bucketName, s3ObjectStorageProviderManager.options.BucketEncryption)
+	s3ObjectStorageProviderManager.kmsKeyChanged = encryptionDefault.SSEAlgorithm == s3Types.ServerSideEncryptionAwsKms
+	return nil
+}
+
+// tagBucket adds the requested tags to the bucket, keeping tags set outside hostit. Tags with
+// the aws: prefix are managed by AWS itself, for example by CloudFormation, and cannot be put.
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) tagBucket(ctx context.Context, bucketName string) error {
+	if len(s3ObjectStorageProviderManager.options.Tags) == 0 {
+		return nil
+	}
+	if err := ValidateResourceTags(s3ObjectStorageProviderManager.options.Tags); err != nil {
+		return err
+	}
+	tags := map[string]string{}
+	getOut, err := s3ObjectStorageProviderManager.s3Client.GetBucketTagging(ctx, &s3.GetBucketTaggingInput{
+		Bucket: aws.String(bucketName),
+	})
+	var responseError *awshttp.ResponseError
+	switch {
+	case err == nil:
+		for _, tag := range getOut.TagSet {
+			if key := aws.ToString(tag.Key); !strings.HasPrefix(strings.ToLower(key), "aws:") {
+				tags[key] = aws.ToString(tag.Value)
+			}
+		}
+	case errors.As(err, &responseError) && responseError.HTTPStatusCode() == 404:
+		// NoSuchTagSet: the bucket has no tags yet
+	default:
+		return fmt.Errorf("failed to read tags of bucket %s: %w", bucketName, err)
+	}
+	for key, value := range s3ObjectStorageProviderManager.options.Tags {
+		tags[key] = value
+	}
+	if len(tags) > maxResourceTags {
+		return fmt.Errorf("bucket %s would have %d tags, more than the %d S3 allows", bucketName, len(tags), maxResourceTags)
+	}
+	_, err = s3ObjectStorageProviderManager.s3Client.PutBucketTagging(ctx, &s3.PutBucketTaggingInput{
+		Bucket:  aws.String(bucketName),
+		Tagging: &s3Types.Tagging{TagSet: s3Tags(tags)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to tag bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// tagExistingResources adds the requested tags to a distribution and certificate created by an
+// earlier deploy. Tags with the same key are overwritten and others are kept.
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) tagExistingResources(ctx context.Context) error {
+	if len(s3ObjectStorageProviderManager.options.Tags) == 0 {
+		return nil
+	}
+	_, err := s3ObjectStorageProviderManager.cloudfrontClient.TagResource(ctx, &cloudfront.TagResourceInput{
+		Resource: aws.String(s3ObjectStorageProviderManager.distributionArn()),
+		Tags:     cloudfrontTags(s3ObjectStorageProviderManager.options.Tags),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to tag CloudFront distribution %s: %w", s3ObjectStorageProviderManager.cloudfrontDistributionId, err)
+	}
+	if s3ObjectStorageProviderManager.certificateArn == "" {
+		return nil
+	}
+	_, err = s3ObjectStorageProviderManager.acmClientUsEast1.AddTagsToCertificate(ctx, &acm.AddTagsToCertificateInput{
+		CertificateArn: aws.String(s3ObjectStorageProviderManager.certificateArn),
+		Tags:           acmTags(s3ObjectStorageProviderManager.options.Tags),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to tag ACM certificate: %w", err)
+	}
+	return nil
+}
+
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) distributionArn() string {
+	return fmt.Sprintf("arn:aws:cloudfront::%s:distribution/%s", s3ObjectStorageProviderManager.awsAccountNumber, s3ObjectStorageProviderManager.cloudfrontDistributionId)
+}
+
+// printKmsKeyPolicyGuidance explains the key policy statement OAC needs once the bucket encrypts
+// with a customer managed key. hostit does not edit key policies, which are often owned elsewhere.
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) printKmsKeyPolicyGuidance() {
+	if !s3ObjectStorageProviderManager.kmsKeyChanged {
+		return
+	}
+	fmt.Printf(`CloudFront can only read objects encrypted with KMS key %s if the key policy allows this
+distribution to decrypt with it. Add this statement to the key policy if it is not there yet:
+{
+  "Sid": "AllowCloudFrontServicePrincipalSSE-KMS",
+  "Effect": "Allow",
+  "Principal": {"Service": "cloudfront.amazonaws.com"},
+  "Action": "kms:Decrypt",
+  "Resource": "*",
+  "Condition": {
+    "StringEquals": {"AWS:SourceArn": "%s"}
+  }
+}
+`, s3ObjectStorageProviderManager.options.KmsKeyId, s3ObjectStorageProviderManager.distributionArn())
+}
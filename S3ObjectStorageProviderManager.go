@@ -2,13 +2,26 @@ package main
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/acm"
 	acmTypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
 	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
@@ -22,7 +35,10 @@ import (
 type S3ObjectStorageProviderManager struct {
 	domainName                       string
 	folderName                       string
+	options                          DeployOptions
 	awsAccountNumber                 string
+	bucket                           string
+	region                           string
 	s3Client                         *s3.Client
 	cloudfrontClient                 *cloudfront.Client
 	acmClientUsEast1                 *acm.Client
@@ -30,6 +46,15 @@ type S3ObjectStorageProviderManager struct {
 	cloudfrontDistributionId         string
 	certificateArn                   string
 	acmValidationRecords             []*route53Types.ResourceRecordSet
+	deploymentState                  *DeploymentState
+	adoptableState                   *DeploymentState
+	changedPaths                     []string
+	releaseId                        string
+	keyPrefix                        string
+	copySourcePrefix                 string
+	kmsKeyChanged                    bool
+	logBucket                        string
+	logPrefix                        string
 }
 
 // HTTPS finalization is disabled for now; handled externally
@@ -37,16 +62,56 @@ type S3ObjectStorageProviderManager struct {
 func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) InstantiateClient() error {
 	cfg, err := config.LoadDefaultConfig(context.Background())
 	if err != nil {
-		return errors.New("issue with getting credentials")
+		return fmt.Errorf("issue with getting credentials: %w", err)
 	}
 	stsClient := sts.NewFromConfig(cfg)
 	result, err := stsClient.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
 	if err != nil {
-		return errors.New("issue with user information")
+		return fmt.Errorf("issue with user information: %w", err)
 	}
 	fmt.Printf("Using AWS Account %s for object storage provider\n", *result.Account)
 	s3ObjectStorageProviderManager.awsAccountNumber = *result.Account
-	s3ObjectStorageProviderManager.s3Client = s3.NewFromConfig(cfg)
+
+	// A previous deploy from this machine is updated in place rather than recreated
+	deploymentState, err := LoadDeploymentState(s3ObjectStorageProviderManager.domainName)
+	if err != nil {
+		return err
+	}
+	if deploymentState != nil && deploymentState.Backend == "s3" {
+		fmt.Printf("Found existing deployment using bucket %s\n", deploymentState.BucketName)
+		s3ObjectStorageProviderManager.deploymentState = deploymentState
+	}
+
+	// The name is checked here, before any call touches the bucket
+	s3ObjectStorageProviderManager.bucket, err = resolveBucketName(s3ObjectStorageProviderManager.deploymentState, s3ObjectStorageProviderManager.options.BucketName, s3ObjectStorageProviderManager.awsAccountNumber, s3ObjectStorageProviderManager.domainName)
+	if err != nil {
+		return err
+	}
+
+	// The bucket region comes from the previous deploy, then -region, then the AWS config
+	region := s3ObjectStorageProviderManager.options.Region
+	if region == "" {
+		region = cfg.Region
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	if s3ObjectStorageProviderManager.deploymentState != nil {
+		// Deploys that predate the region option could only create buckets in us-east-1
+		stateRegion := s3ObjectStorageProviderManager.deploymentState.Region
+		if stateRegion == "" {
+			stateRegion = "us-east-1"
+		}
+		if s3ObjectStorageProviderManager.options.Region != "" && s3ObjectStorageProviderManager.options.Region != stateRegion {
+			return fmt.Errorf("bucket %s is in %s; it cannot be moved to %s", s3ObjectStorageProviderManager.deploymentState.BucketName, stateRegion, s3ObjectStorageProviderManager.options.Region)
+		}
+		region = stateRegion
+	}
+	s3ObjectStorageProviderManager.region = region
+	fmt.Printf("Using region %s for the storage bucket\n", region)
+	s3ObjectStorageProviderManager.s3Client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Region = region
+	})
 	s3ObjectStorageProviderManager.cloudfrontClient = cloudfront.NewFromConfig(cfg)
 	// CloudFront requires ACM certificates in us-east-1
 	acmCfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion("us-east-1"))
@@ -57,20 +122,198 @@ func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) Instantiat
 	return nil
 }
 
-func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) VerifyNamespace() (bool, error) {
-	return true, nil
+// VerifyNamespace checks whether the site's bucket is free. A bucket this account already owns
+// is looked up together with its hostit distribution so that it can be adopted.
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) VerifyNamespace() (bool, error) {
+	if s3ObjectStorageProviderManager.s3Client == nil || s3ObjectStorageProviderManager.cloudfrontClient == nil {
+		return false, errors.New("aws clients not instantiated")
+	}
+	if s3ObjectStorageProviderManager.deploymentState != nil {
+		return true, nil
+	}
+	ctx := context.Background()
+	bucketName := s3ObjectStorageProviderManager.bucketName()
+
+	bucketRegion, err := manager.GetBucketRegion(ctx, s3ObjectStorageProviderManager.s3Client, bucketName)
+	var bucketNotFound manager.BucketNotFound
+	if errors.As(err, &bucketNotFound) && s3ObjectStorageProviderManager.options.BucketName == "" {
+		// Sites deployed by earlier versions live in a bucket named after the dotted domain
+		legacyBucketName := LegacyBucketName(s3ObjectStorageProviderManager.awsAccountNumber, s3ObjectStorageProviderManager.domainName)
+		if legacyBucketName != bucketName {
+			legacyRegion, legacyErr := manager.GetBucketRegion(ctx, s3ObjectStorageProviderManager.s3Client, legacyBucketName)
+			switch {
+			case legacyErr == nil:
+				fmt.Printf("Found bucket %s created by an earlier version of hostit\n", legacyBucketName)
+				s3ObjectStorageProviderManager.bucket = legacyBucketName
+				bucketName, bucketRegion, err = legacyBucketName, legacyRegion, nil
+			case !errors.As(legacyErr, &bucketNotFound):
+				return false, fmt.Errorf("failed looking up bucket %s: %w", legacyBucketName, legacyErr)
+			}
+		}
+	}
+	if errors.As(err, &bucketNotFound) {
+		// Only buckets hostit creates have to avoid dots
+		if err := ValidateNewBucketName(bucketName); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed looking up bucket %s: %w", bucketName, err)
+	}
+	_, err = s3ObjectStorageProviderManager.s3Client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket:              aws.String(bucketName),
+		ExpectedBucketOwner: aws.String(s3ObjectStorageProviderManager.awsAccountNumber),
+	}, func(o *s3.Options) {
+		o.Region = bucketRegion
+	})
+	var responseError *awshttp.ResponseError
+	if errors.As(err, &responseError) && responseError.HTTPStatusCode() == 403 {
+		return false, fmt.Errorf("bucket %s exists but is not owned by account %s", bucketName, s3ObjectStorageProviderManager.awsAccountNumber)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed checking bucket %s: %w", bucketName, err)
+	}
+
+	adoptableState := &DeploymentState{
+		Domain:     s3ObjectStorageProviderManager.domainName,
+		Backend:    "s3",
+		BucketName: bucketName,
+		Region:     bucketRegion,
+	}
+	distribution, err := s3ObjectStorageProviderManager.findDistribution(ctx, bucketName)
+	if err != nil {
+		return false, err
+	}
+	if distribution != nil {
+		adoptableState.DistributionId = aws.ToString(distribution.Id)
+		adoptableState.DistributionDomainName = aws.ToString(distribution.DomainName)
+		if distribution.ViewerCertificate != nil {
+			adoptableState.CertificateArn = aws.ToString(distribution.ViewerCertificate.ACMCertificateArn)
+		}
+	}
+	s3ObjectStorageProviderManager.adoptableState = adoptableState
+	return false, nil
 }
 
-func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) CreateStorageInstance() error {
+// findDistribution returns the distribution hostit created for bucketName, recognized by its
+// caller reference, or nil if there is none
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) findDistribution(ctx context.Context, bucketName string) (*cloudfrontTypes.DistributionSummary, error) {
+	callerReference := fmt.Sprintf("hostit-%s", bucketName)
+	paginator := cloudfront.NewListDistributionsPaginator(s3ObjectStorageProviderManager.cloudfrontClient, &cloudfront.ListDistributionsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed listing CloudFront distributions: %w", err)
+		}
+		if page.DistributionList == nil {
+			break
+		}
+		for _, summary := range page.DistributionList.Items {
+			// Only distributions serving the bucket are worth reading the full config of
+			servesBucket := false
+			if summary.Origins != nil {
+				for _, origin := range summary.Origins.Items {
+					if strings.HasPrefix(aws.ToString(origin.DomainName), bucketName+".s3.") {
+						servesBucket = true
+					}
+				}
+			}
+			if !servesBucket {
+				continue
+			}
+			getOut, err := s3ObjectStorageProviderManager.cloudfrontClient.GetDistributionConfig(ctx, &cloudfront.GetDistributionConfigInput{
+				Id: summary.Id,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed reading CloudFront distribution %s: %w", aws.ToString(summary.Id), err)
+			}
+			if getOut.DistributionConfig != nil && aws.ToString(getOut.DistributionConfig.CallerReference) == callerReference {
+				return &summary, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) findOriginAccessControl(ctx context.Context, name string) (*string, error) {
+	paginator := cloudfront.NewListOriginAccessControlsPaginator(s3ObjectStorageProviderManager.cloudfrontClient, &cloudfront.ListOriginAccessControlsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed listing Origin Access Controls: %w", err)
+		}
+		if page.OriginAccessControlList == nil {
+			break
+		}
+		for _, summary := range page.OriginAccessControlList.Items {
+			if aws.ToString(summary.Name) == name {
+				return summary.Id, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("Origin Access Control '%s' not found", name)
+}
+
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) ExistingDeployment() string {
+	adoptableState := s3ObjectStorageProviderManager.adoptableState
+	if adoptableState == nil {
+		return ""
+	}
+	description := fmt.Sprintf("Bucket %s already exists in %s", adoptableState.BucketName, adoptableState.Region)
+	if adoptableState.DistributionId != "" {
+		description += fmt.Sprintf(" with CloudFront distribution %s (%s)", adoptableState.DistributionId, adoptableState.DistributionDomainName)
+	}
+	return description
+}
+
+// AdoptExistingDeployment records the resources found by VerifyNamespace as this site's
+// deployment, so the rest of the run updates them like a previous deploy
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) AdoptExistingDeployment() error {
+	adoptableState := s3ObjectStorageProviderManager.adoptableState
+	if adoptableState == nil {
+		return errors.New("no existing deployment to adopt")
+	}
+	if s3ObjectStorageProviderManager.options.Region != "" && s3ObjectStorageProviderManager.options.Region != adoptableState.Region {
+		return fmt.Errorf("bucket %s is in %s; it cannot be moved to %s", adoptableState.BucketName, adoptableState.Region, s3ObjectStorageProviderManager.options.Region)
+	}
+	if err := adoptableState.Save(); err != nil {
+		return err
+	}
+	s3ObjectStorageProviderManager.deploymentState = adoptableState
+	s3ObjectStorageProviderManager.adoptableState = nil
+	if adoptableState.Region != s3ObjectStorageProviderManager.region {
+		s3ObjectStorageProviderManager.region = adoptableState.Region
+		s3ObjectStorageProviderManager.s3Client = s3.New(s3ObjectStorageProviderManager.s3Client.Options(), func(o *s3.Options) {
+			o.Region = adoptableState.Region
+		})
+	}
+	return nil
+}
+
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) CreateStorageInstance() error {
 	if s3ObjectStorageProviderManager.s3Client == nil {
 		return errors.New("s3 client not instantiated")
 	}
-	bucketName := fmt.Sprintf("%s-%s-hostit", s3ObjectStorageProviderManager.awsAccountNumber, s3ObjectStorageProviderManager.domainName)
-	_, err := s3ObjectStorageProviderManager.s3Client.CreateBucket(context.TODO(), &s3.CreateBucketInput{
+	bucketName := s3ObjectStorageProviderManager.bucketName()
+	if s3ObjectStorageProviderManager.deploymentState != nil {
+		if err := s3ObjectStorageProviderManager.configureBucket(context.TODO(), bucketName); err != nil {
+			return err
+		}
+		return s3ObjectStorageProviderManager.ensureLogBucket(context.TODO())
+	}
+	createBucketInput := &s3.CreateBucketInput{
 		Bucket: &bucketName,
-	})
+	}
+	// us-east-1 is the default location and rejects an explicit constraint
+	if s3ObjectStorageProviderManager.region != "us-east-1" {
+		createBucketInput.CreateBucketConfiguration = &s3Types.CreateBucketConfiguration{
+			LocationConstraint: s3Types.BucketLocationConstraint(s3ObjectStorageProviderManager.region),
+		}
+	}
+	_, err := s3ObjectStorageProviderManager.s3Client.CreateBucket(context.TODO(), createBucketInput)
 	if err != nil {
-		return errors.New("issue with creating bucket")
+		return fmt.Errorf("issue with creating bucket %s in %s: %w", bucketName, s3ObjectStorageProviderManager.region, err)
 	}
 
 	securityPolicyBoolean := true
@@ -85,12 +328,15 @@ func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) CreateStora
 	})
 
 	if err != nil {
-		return errors.New("issue with setting security policy in s3 bucket")
+		return fmt.Errorf("issue with setting security policy in s3 bucket: %w", err)
 	}
-	return nil
+	if err := s3ObjectStorageProviderManager.configureBucket(context.TODO(), bucketName); err != nil {
+		return err
+	}
+	return s3ObjectStorageProviderManager.ensureLogBucket(context.TODO())
 }
 
-func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) UploadFilesToNewInstance() error {
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) UploadFilesToNewInstance() error {
 	const maxFileSizeBytes int64 = 1 * 1024 * 1024 * 1024 // 1GB
 
 	if s3ObjectStorageProviderManager.s3Client == nil {
@@ -100,45 +346,374 @@ func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) UploadFiles
 		return errors.New("aws account number not set; call InstantiateClient first")
 	}
 
-	bucketName := fmt.Sprintf("%s-%s-hostit", s3ObjectStorageProviderManager.awsAccountNumber, s3ObjectStorageProviderManager.domainName)
+	bucketName := s3ObjectStorageProviderManager.bucketName()
 
 	finder := NewUploadFileFinder()
 	filesToUpload, err := finder.FindFiles(s3ObjectStorageProviderManager.folderName, maxFileSizeBytes)
 	if err != nil {
 		return err
 	}
+	// Redirect and header rules are applied by CloudFront rather than served
+	filesToUpload = slices.DeleteFunc(filesToUpload, func(repoPath string) bool {
+		return IsRedirectRulesFile(repoPath) || IsHeaderRulesFile(repoPath)
+	})
+	if requiredPage := s3ObjectStorageProviderManager.options.SiteMode.RequiredPage(); requiredPage != "" && !slices.Contains(filesToUpload, requiredPage) {
+		return fmt.Errorf("site mode '%s' requires '%s' in the upload folder", s3ObjectStorageProviderManager.options.SiteMode, requiredPage)
+	}
+	contentTypeResolver := NewContentTypeResolver(s3ObjectStorageProviderManager.options.ContentTypeOverrides)
+
+	// Atomic releases go under a fresh prefix, compared against the release currently live
+	listPrefix := ""
+	if s3ObjectStorageProviderManager.options.AtomicReleases {
+		s3ObjectStorageProviderManager.releaseId = NewReleaseId()
+		s3ObjectStorageProviderManager.keyPrefix = releaseKeyPrefix(s3ObjectStorageProviderManager.releaseId)
+		if s3ObjectStorageProviderManager.deploymentState != nil && s3ObjectStorageProviderManager.deploymentState.ReleaseId != "" {
+			listPrefix = releaseKeyPrefix(s3ObjectStorageProviderManager.deploymentState.ReleaseId)
+			s3ObjectStorageProviderManager.copySourcePrefix = listPrefix
+		}
+		fmt.Printf("Uploading release %s to %s\n", s3ObjectStorageProviderManager.releaseId, s3ObjectStorageProviderManager.keyPrefix)
+	}
 
 	ctx := context.Background()
+	existingETags := map[string]string{}
+	if !s3ObjectStorageProviderManager.options.AtomicReleases || listPrefix != "" {
+		existingETags, err = s3ObjectStorageProviderManager.listObjectETags(ctx, bucketName, listPrefix)
+		if err != nil {
+			return err
+		}
+	}
+	// Atomic release copies may still be live; pruneReleases removes them after CloudFront
+	// switches back to the bucket root
+	if listPrefix == "" {
+		for key := range existingETags {
+			if strings.HasPrefix(key, releasesKeyPrefix) {
+				delete(existingETags, key)
+			}
+		}
+	}
+
+	// Files larger than the part size are sent as multipart uploads by the transfer manager
+	partSize := max(s3ObjectStorageProviderManager.options.MultipartThresholdBytes, manager.MinUploadPartSize)
+	uploader := manager.NewUploader(s3ObjectStorageProviderManager.s3Client, func(uploader *manager.Uploader) {
+		uploader.PartSize = partSize
+	})
+
+	var changedPaths []string
+	var uploadErrs []error
+	var resultsMutex sync.Mutex
+	uploadedKeys := NewSet[string]()
+	jobs := make(chan string)
+	var workers sync.WaitGroup
+	for range max(s3ObjectStorageProviderManager.options.UploadConcurrency, 1) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for repoPath := range jobs {
+				fullPath := filepath.Join(s3ObjectStorageProviderManager.folderName, filepath.FromSlash(repoPath))
+				uploaded, err := s3ObjectStorageProviderManager.uploadObjectIfChanged(ctx, uploader, contentTypeResolver, bucketName, repoPath, fullPath, existingETags[repoPath], partSize)
+				resultsMutex.Lock()
+				if err != nil {
+					uploadErrs = append(uploadErrs, err)
+				} else if uploaded {
+					changedPaths = append(changedPaths, repoPath)
+				}
+				resultsMutex.Unlock()
+			}
+		}()
+	}
 	for _, repoPath := range filesToUpload {
-		fullPath := filepath.Join(s3ObjectStorageProviderManager.folderName, filepath.FromSlash(repoPath))
+		uploadedKeys.Add(repoPath)
+		jobs <- repoPath
+	}
+	close(jobs)
+	workers.Wait()
+	sort.Strings(changedPaths)
+
+	if len(uploadErrs) > 0 {
+		for _, e := range uploadErrs {
+			log.Printf("upload error: %v", e)
+		}
+		// Nothing is deleted after a partial upload so the live site keeps every file it had
+		return fmt.Errorf("completed with %d upload errors: %w", len(uploadErrs), errors.Join(uploadErrs...))
+	}
+
+	var staleKeys []string
+	for key := range existingETags {
+		if !uploadedKeys.Contains(key) {
+			staleKeys = append(staleKeys, key)
+		}
+	}
+	sort.Strings(staleKeys)
+	// A new release prefix never had the removed files, so there is nothing to delete
+	if !s3ObjectStorageProviderManager.options.AtomicReleases {
+		if err := s3ObjectStorageProviderManager.deleteObjects(ctx, bucketName, staleKeys); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d files uploaded, %d unchanged, %d removed\n", len(changedPaths), len(filesToUpload)-len(changedPaths), len(staleKeys))
+	changedPaths = append(changedPaths, staleKeys...)
+	s3ObjectStorageProviderManager.changedPaths = changedPaths
+	return s3ObjectStorageProviderManager.recordRelease(ctx, "")
+}
+
+// uploadObjectIfChanged uploads repoPath unless the bucket already holds identical content,
+// retrying with exponential backoff. Identical content whose metadata is out of date, such as a
+// Content-Type from an earlier deploy or a changed Cache-Control rule, is copied in place with the
+// new metadata instead. It reports whether the object changed.
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) uploadObjectIfChanged(ctx context.Context, uploader *manager.Uploader, contentTypeResolver *ContentTypeResolver, bucketName string, repoPath string, fullPath string, existingETag string, partSize int64) (bool, error) {
+	contentMetadata, err := contentTypeResolver.Resolve(repoPath, fullPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to determine content type of '%s': %w", fullPath, err)
+	}
+	cacheControl, _ := s3ObjectStorageProviderManager.options.CacheControlRules.Match(repoPath)
+
+	contentDigest, err := s3ETagForFile(fullPath, partSize)
+	if err != nil {
+		return false, err
+	}
+	if existingETag != "" {
+		headOut, err := s3ObjectStorageProviderManager.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucketName),
+			Key:    aws.String(s3ObjectStorageProviderManager.copySourcePrefix + repoPath),
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to read metadata of '%s': %w", repoPath, err)
+		}
+		// SSE-KMS ETags are not content digests, so the digest recorded at upload is preferred.
+		// Objects uploaded before it was recorded fall back to the ETag.
+		existingDigest, ok := headOut.Metadata[contentDigestMetadataKey]
+		if !ok {
+			existingDigest = existingETag
+		}
+		if existingDigest == contentDigest {
+			if objectMetadataMatches(headOut, contentMetadata, cacheControl) {
+				return false, s3ObjectStorageProviderManager.copyFromPreviousRelease(ctx, bucketName, repoPath)
+			}
+			if err := s3ObjectStorageProviderManager.replaceObjectMetadata(ctx, bucketName, repoPath, contentMetadata, cacheControl, contentDigest); err != nil {
+				return false, err
+			}
+			log.Printf("Updated metadata of '%s'", repoPath)
+			return true, nil
+		}
+	}
+
+	attempts := max(s3ObjectStorageProviderManager.options.UploadRetries, 0) + 1
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err = s3ObjectStorageProviderManager.uploadObject(ctx, uploader, bucketName, repoPath, fullPath, contentMetadata, cacheControl, contentDigest)
+		if err == nil {
+			log.Printf("Uploaded '%s'", repoPath)
+			return true, nil
+		}
+		if attempt >= attempts {
+			return false, fmt.Errorf("failed to upload '%s' after %d attempts: %w", repoPath, attempts, err)
+		}
+		log.Printf("retrying '%s' in %s: %v", repoPath, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) uploadObject(ctx context.Context, uploader *manager.Uploader, bucketName string, repoPath string, fullPath string, contentMetadata ContentMetadata, cacheControl string, contentDigest string) error {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to open '%s': %w", fullPath, err)
+	}
+	defer f.Close()
+	putObjectInput := &s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(s3ObjectStorageProviderManager.keyPrefix + repoPath),
+		Body:        f,
+		ContentType: aws.String(contentMetadata.ContentType),
+		Metadata:    map[string]string{contentDigestMetadataKey: contentDigest},
+	}
+	if contentMetadata.ContentEncoding != "" {
+		putObjectInput.ContentEncoding = aws.String(contentMetadata.ContentEncoding)
+	}
+	if cacheControl != "" {
+		putObjectInput.CacheControl = aws.String(cacheControl)
+	}
+	_, err = uploader.Upload(ctx, putObjectInput)
+	return err
+}
+
+// replaceObjectMetadata copies repoPath from the live copy to its new key, which may be the same
+// key, with the metadata this deploy wants
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) replaceObjectMetadata(ctx context.Context, bucketName string, repoPath string, contentMetadata ContentMetadata, cacheControl string, contentDigest string) error {
+	copyObjectInput := &s3.CopyObjectInput{
+		Bucket:            aws.String(bucketName),
+		Key:               aws.String(s3ObjectStorageProviderManager.keyPrefix + repoPath),
+		CopySource:        aws.String(bucketName + "/" + url.PathEscape(s3ObjectStorageProviderManager.copySourcePrefix+repoPath)),
+		MetadataDirective: s3Types.MetadataDirectiveReplace,
+		ContentType:       aws.String(contentMetadata.ContentType),
+		Metadata:          map[string]string{contentDigestMetadataKey: contentDigest},
+	}
+	if contentMetadata.ContentEncoding != "" {
+		copyObjectInput.ContentEncoding = aws.String(contentMetadata.ContentEncoding)
+	}
+	if cacheControl != "" {
+		copyObjectInput.CacheControl = aws.String(cacheControl)
+	}
+	if _, err := s3ObjectStorageProviderManager.s3Client.CopyObject(ctx, copyObjectInput); err != nil {
+		return fmt.Errorf("failed to update metadata of '%s': %w", repoPath, err)
+	}
+	return nil
+}
 
-		f, err := os.Open(fullPath)
+func objectMetadataMatches(headOut *s3.HeadObjectOutput, contentMetadata ContentMetadata, cacheControl string) bool {
+	return aws.ToString(headOut.ContentType) == contentMetadata.ContentType &&
+		aws.ToString(headOut.ContentEncoding) == contentMetadata.ContentEncoding &&
+		aws.ToString(headOut.CacheControl) == cacheControl
+}
+
+// listObjectETags maps every key under prefix, with the prefix removed, to its unquoted ETag
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) listObjectETags(ctx context.Context, bucketName string, prefix string) (map[string]string, error) {
+	etags := map[string]string{}
+	paginator := s3.NewListObjectsV2Paginator(s3ObjectStorageProviderManager.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to open '%s': %w", fullPath, err)
+			return nil, fmt.Errorf("failed to list objects in bucket '%s': %w", bucketName, err)
+		}
+		for _, object := range page.Contents {
+			if object.Key == nil {
+				continue
+			}
+			etags[strings.TrimPrefix(*object.Key, prefix)] = strings.Trim(aws.ToString(object.ETag), `"`)
 		}
-		_, putErr := s3ObjectStorageProviderManager.s3Client.PutObject(ctx, &s3.PutObjectInput{
-			Bucket: &bucketName,
-			Key:    &repoPath,
-			Body:   f,
+	}
+	return etags, nil
+}
+
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) deleteObjects(ctx context.Context, bucketName string, keys []string) error {
+	objects := make([]s3Types.ObjectIdentifier, 0, len(keys))
+	for _, key := range keys {
+		objects = append(objects, s3Types.ObjectIdentifier{Key: aws.String(key)})
+	}
+	return s3ObjectStorageProviderManager.deleteObjectIdentifiers(ctx, bucketName, objects)
+}
+
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) deleteObjectIdentifiers(ctx context.Context, bucketName string, objects []s3Types.ObjectIdentifier) error {
+	// DeleteObjects accepts at most 1000 keys per request
+	const batchSize = 1000
+	for start := 0; start < len(objects); start += batchSize {
+		end := min(start+batchSize, len(objects))
+		out, err := s3ObjectStorageProviderManager.s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucketName),
+			Delete: &s3Types.Delete{Objects: objects[start:end], Quiet: aws.Bool(true)},
 		})
-		closeErr := f.Close()
-		if putErr != nil {
-			return fmt.Errorf("failed to upload '%s': %w", repoPath, putErr)
+		if err != nil {
+			return fmt.Errorf("failed to delete removed files: %w", err)
 		}
-		if closeErr != nil {
-			return fmt.Errorf("failed to close file '%s': %w", fullPath, closeErr)
+		if len(out.Errors) > 0 {
+			return fmt.Errorf("failed to delete '%s': %s", aws.ToString(out.Errors[0].Key), aws.ToString(out.Errors[0].Message))
 		}
 	}
 	return nil
 }
 
+// InvalidateCache clears the changed paths from an existing distribution's edge caches. A
+// distribution created in this run has nothing cached yet, so it is skipped.
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) InvalidateCache() error {
+	if s3ObjectStorageProviderManager.deploymentState == nil || s3ObjectStorageProviderManager.deploymentState.DistributionId == "" {
+		return nil
+	}
+	if len(s3ObjectStorageProviderManager.changedPaths) == 0 {
+		fmt.Println("No changed files; skipping CloudFront invalidation")
+		return nil
+	}
+
+	invalidationPaths := NewSet[string]()
+	for _, repoPath := range s3ObjectStorageProviderManager.changedPaths {
+		invalidationPaths.Add("/" + repoPath)
+		// Directory indexes are also cached under the directory URL itself
+		if path.Base(repoPath) == "index.html" {
+			invalidationPaths.Add("/" + strings.TrimSuffix(repoPath, "index.html"))
+		}
+	}
+	var items []string
+	if invalidationPaths.Size() > s3ObjectStorageProviderManager.options.InvalidationPathThreshold {
+		items = []string{"/*"}
+	} else {
+		for invalidationPath := range invalidationPaths {
+			items = append(items, invalidationPath)
+		}
+		sort.Strings(items)
+	}
+
+	ctx := context.Background()
+	distributionId := s3ObjectStorageProviderManager.deploymentState.DistributionId
+	out, err := s3ObjectStorageProviderManager.cloudfrontClient.CreateInvalidation(ctx, &cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(distributionId),
+		InvalidationBatch: &cloudfrontTypes.InvalidationBatch{
+			CallerReference: aws.String(fmt.Sprintf("hostit-%d", time.Now().UnixNano())),
+			Paths: &cloudfrontTypes.Paths{
+				Quantity: aws.Int32(int32(len(items))),
+				Items:    items,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create CloudFront invalidation: %w", err)
+	}
+	if out.Invalidation == nil || out.Invalidation.Id == nil {
+		return errors.New("unexpected empty invalidation response")
+	}
+	fmt.Printf("Created CloudFront invalidation %s for %d paths\n", *out.Invalidation.Id, len(items))
+	if !s3ObjectStorageProviderManager.options.WaitForInvalidation {
+		return nil
+	}
+	fmt.Println("Waiting for CloudFront invalidation to complete")
+	waiter := cloudfront.NewInvalidationCompletedWaiter(s3ObjectStorageProviderManager.cloudfrontClient)
+	err = waiter.Wait(ctx, &cloudfront.GetInvalidationInput{
+		DistributionId: aws.String(distributionId),
+		Id:             out.Invalidation.Id,
+	}, 30*time.Minute)
+	if err != nil {
+		return fmt.Errorf("failed waiting for CloudFront invalidation: %w", err)
+	}
+	return nil
+}
+
 func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) CreateAvailableDomain() error {
 	if s3ObjectStorageProviderManager.cloudfrontClient == nil || s3ObjectStorageProviderManager.s3Client == nil {
 		return errors.New("aws clients not instantiated")
 	}
 
-	bucketName := fmt.Sprintf("%s-%s-hostit", s3ObjectStorageProviderManager.awsAccountNumber, s3ObjectStorageProviderManager.domainName)
 	ctx := context.Background()
+	settings, err := s3ObjectStorageProviderManager.prepareDistributionSettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s3ObjectStorageProviderManager.deploymentState != nil && s3ObjectStorageProviderManager.deploymentState.DistributionId != "" {
+		s3ObjectStorageProviderManager.cloudfrontDistributionId = s3ObjectStorageProviderManager.deploymentState.DistributionId
+		s3ObjectStorageProviderManager.cloudfrontDistributionDomainName = s3ObjectStorageProviderManager.deploymentState.DistributionDomainName
+		s3ObjectStorageProviderManager.certificateArn = s3ObjectStorageProviderManager.deploymentState.CertificateArn
+		if err := s3ObjectStorageProviderManager.updateDistribution(ctx, settings); err != nil {
+			return err
+		}
+		if err := s3ObjectStorageProviderManager.tagExistingResources(ctx); err != nil {
+			return err
+		}
+		s3ObjectStorageProviderManager.printKmsKeyPolicyGuidance()
+		// The distribution now serves the new release, if any
+		s3ObjectStorageProviderManager.deploymentState.ReleaseId = s3ObjectStorageProviderManager.releaseId
+		if s3ObjectStorageProviderManager.logBucket != "" {
+			// Earlier log locations stay recorded after logging is turned off, so stats can read them
+			s3ObjectStorageProviderManager.deploymentState.LogBucket = s3ObjectStorageProviderManager.logBucket
+			s3ObjectStorageProviderManager.deploymentState.LogPrefix = s3ObjectStorageProviderManager.logPrefix
+		}
+		if err := s3ObjectStorageProviderManager.deploymentState.Save(); err != nil {
+			return err
+		}
+		return s3ObjectStorageProviderManager.pruneReleases(ctx)
+	}
+
+	bucketName := s3ObjectStorageProviderManager.bucketName()
 
 	// Create Origin Access Control for the S3 origin
 	oacName := fmt.Sprintf("hostit-oac-%s", bucketName)
@@ -151,71 +726,78 @@ func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) CreateAvai
 			SigningProtocol:               cloudfrontTypes.OriginAccessControlSigningProtocolsSigv4,
 		},
 	})
-	if err != nil {
+	var originAccessControlId *string
+	var oacExists *cloudfrontTypes.OriginAccessControlAlreadyExists
+	switch {
+	case err == nil:
+		originAccessControlId = oacOut.OriginAccessControl.Id
+	case errors.As(err, &oacExists):
+		// Left behind by an earlier deploy of an adopted bucket
+		originAccessControlId, err = s3ObjectStorageProviderManager.findOriginAccessControl(ctx, oacName)
+		if err != nil {
+			return err
+		}
+	default:
 		return fmt.Errorf("failed creating Origin Access Control: %w", err)
 	}
 
 	originId := "s3-origin"
-	s3Domain := fmt.Sprintf("%s.s3.amazonaws.com", bucketName)
-	createDistOut, err := s3ObjectStorageProviderManager.cloudfrontClient.CreateDistribution(ctx, &cloudfront.CreateDistributionInput{
-		DistributionConfig: &cloudfrontTypes.DistributionConfig{
-			CallerReference:   aws.String(fmt.Sprintf("hostit-%s", bucketName)),
-			Comment:           aws.String("Hostit distribution for S3 static site"),
-			Enabled:           aws.Bool(true),
-			DefaultRootObject: aws.String("index.html"),
-			Origins: &cloudfrontTypes.Origins{
-				Quantity: aws.Int32(1),
-				Items: []cloudfrontTypes.Origin{
-					{
-						Id:         aws.String(originId),
-						DomainName: aws.String(s3Domain),
-						S3OriginConfig: &cloudfrontTypes.S3OriginConfig{
-							OriginAccessIdentity: aws.String(""),
-						},
-						OriginAccessControlId: oacOut.OriginAccessControl.Id,
-					},
-				},
-			},
-			DefaultCacheBehavior: &cloudfrontTypes.DefaultCacheBehavior{
-				TargetOriginId:       aws.String(originId),
-				ViewerProtocolPolicy: cloudfrontTypes.ViewerProtocolPolicyRedirectToHttps,
-				Compress:             aws.Bool(true),
-				AllowedMethods: &cloudfrontTypes.AllowedMethods{
-					Quantity: aws.Int32(3),
-					Items:    []cloudfrontTypes.Method{cloudfrontTypes.MethodGet, cloudfrontTypes.MethodHead, cloudfrontTypes.MethodOptions},
-					CachedMethods: &cloudfrontTypes.CachedMethods{
-						Quantity: aws.Int32(2),
-						Items:    []cloudfrontTypes.Method{cloudfrontTypes.MethodGet, cloudfrontTypes.MethodHead},
+	s3Domain := fmt.Sprintf("%s.s3.%s.amazonaws.com", bucketName, s3ObjectStorageProviderManager.region)
+	distributionConfig := &cloudfrontTypes.DistributionConfig{
+		CallerReference: aws.String(fmt.Sprintf("hostit-%s", bucketName)),
+		Comment:         aws.String("Hostit distribution for S3 static site"),
+		Enabled:         aws.Bool(true),
+		Origins: &cloudfrontTypes.Origins{
+			Quantity: aws.Int32(1),
+			Items: []cloudfrontTypes.Origin{
+				{
+					Id:         aws.String(originId),
+					DomainName: aws.String(s3Domain),
+					S3OriginConfig: &cloudfrontTypes.S3OriginConfig{
+						OriginAccessIdentity: aws.String(""),
 					},
+					OriginAccessControlId: originAccessControlId,
 				},
-				ForwardedValues: &cloudfrontTypes.ForwardedValues{
-					QueryString: aws.Bool(false),
-					Cookies: &cloudfrontTypes.CookiePreference{
-						Forward: cloudfrontTypes.ItemSelectionNone,
-					},
-				},
-				MinTTL: aws.Int64(0),
-			},
-			ViewerCertificate: &cloudfrontTypes.ViewerCertificate{
-				CloudFrontDefaultCertificate: aws.Bool(true),
 			},
 		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed creating CloudFront distribution: %w", err)
+		ViewerCertificate: &cloudfrontTypes.ViewerCertificate{
+			CloudFrontDefaultCertificate: aws.Bool(true),
+		},
+	}
+	applyDistributionSettings(distributionConfig, originId, settings)
+	var distribution *cloudfrontTypes.Distribution
+	if len(s3ObjectStorageProviderManager.options.Tags) > 0 {
+		createDistOut, err := s3ObjectStorageProviderManager.cloudfrontClient.CreateDistributionWithTags(ctx, &cloudfront.CreateDistributionWithTagsInput{
+			DistributionConfigWithTags: &cloudfrontTypes.DistributionConfigWithTags{
+				DistributionConfig: distributionConfig,
+				Tags:               cloudfrontTags(s3ObjectStorageProviderManager.options.Tags),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed creating CloudFront distribution: %w", err)
+		}
+		distribution = createDistOut.Distribution
+	} else {
+		createDistOut, err := s3ObjectStorageProviderManager.cloudfrontClient.CreateDistribution(ctx, &cloudfront.CreateDistributionInput{
+			DistributionConfig: distributionConfig,
+		})
+		if err != nil {
+			return fmt.Errorf("failed creating CloudFront distribution: %w", err)
+		}
+		distribution = createDistOut.Distribution
 	}
-	if createDistOut == nil || createDistOut.Distribution == nil || createDistOut.Distribution.DomainName == nil || createDistOut.Distribution.Id == nil {
+	if distribution == nil || distribution.DomainName == nil || distribution.Id == nil {
 		return errors.New("unexpected empty distribution response")
 	}
 
 	// Persist distribution details for DNS generation
-	s3ObjectStorageProviderManager.cloudfrontDistributionDomainName = *createDistOut.Distribution.DomainName
-	s3ObjectStorageProviderManager.cloudfrontDistributionId = *createDistOut.Distribution.Id
+	s3ObjectStorageProviderManager.cloudfrontDistributionDomainName = *distribution.DomainName
+	s3ObjectStorageProviderManager.cloudfrontDistributionId = *distribution.Id
 
 	// Attach S3 bucket policy to allow CloudFront (OAC) to read objects
 	bucketArn := fmt.Sprintf("arn:aws:s3:::%s", bucketName)
 	objectsArn := fmt.Sprintf("%s/*", bucketArn)
-	distributionArn := fmt.Sprintf("arn:aws:cloudfront::%s:distribution/%s", s3ObjectStorageProviderManager.awsAccountNumber, s3ObjectStorageProviderManager.cloudfrontDistributionId)
+	distributionArn := s3ObjectStorageProviderManager.distributionArn()
 	policy := fmt.Sprintf(`{
 		"Version": "2012-10-17",
 		"Statement": [
@@ -239,6 +821,7 @@ func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) CreateAvai
 	if err != nil {
 		return fmt.Errorf("failed attaching S3 bucket policy for OAC: %w", err)
 	}
+	s3ObjectStorageProviderManager.printKmsKeyPolicyGuidance()
 
 	// 4) Request ACM certificate for the custom domain (DNS validation)
 	if s3ObjectStorageProviderManager.acmClientUsEast1 == nil {
@@ -247,6 +830,7 @@ func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) CreateAvai
 	certOut, err := s3ObjectStorageProviderManager.acmClientUsEast1.RequestCertificate(ctx, &acm.RequestCertificateInput{
 		DomainName:       aws.String(s3ObjectStorageProviderManager.domainName),
 		ValidationMethod: acmTypes.ValidationMethodDns,
+		Tags:             acmTags(s3ObjectStorageProviderManager.options.Tags),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to request ACM certificate: %w", err)
@@ -282,6 +866,215 @@ func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) CreateAvai
 	}
 	s3ObjectStorageProviderManager.acmValidationRecords = validationRRSets
 
+	deploymentState := &DeploymentState{
+		Domain:                 s3ObjectStorageProviderManager.domainName,
+		Backend:                "s3",
+		BucketName:             bucketName,
+		Region:                 s3ObjectStorageProviderManager.region,
+		DistributionId:         s3ObjectStorageProviderManager.cloudfrontDistributionId,
+		DistributionDomainName: s3ObjectStorageProviderManager.cloudfrontDistributionDomainName,
+		CertificateArn:         s3ObjectStorageProviderManager.certificateArn,
+		ReleaseId:              s3ObjectStorageProviderManager.releaseId,
+		LogBucket:              s3ObjectStorageProviderManager.logBucket,
+		LogPrefix:              s3ObjectStorageProviderManager.logPrefix,
+	}
+	if err := deploymentState.Save(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// viewerRequestFunction collects the redirect rules of the upload folder and the request
+// rewrites the deploy options ask for. Basic auth runs first so nothing, not even a redirect, is
+// answered without credentials; redirects come next so they match the requested path.
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) viewerRequestFunction() (ViewerRequestFunction, error) {
+	var viewerRequestFunction ViewerRequestFunction
+	if credentials := s3ObjectStorageProviderManager.options.BasicAuthCredentials; len(credentials) > 0 {
+		step, err := basicAuthStep(s3ObjectStorageProviderManager.domainName, credentials)
+		if err != nil {
+			return viewerRequestFunction, err
+		}
+		viewerRequestFunction.AddStep(step)
+		fmt.Printf("Requiring basic auth for %d users\n", len(credentials))
+	}
+	redirectRules, err := LoadRedirectRules(s3ObjectStorageProviderManager.folderName)
+	if err != nil {
+		return viewerRequestFunction, err
+	}
+	if len(redirectRules) > 0 {
+		step, err := redirectsStep(redirectRules)
+		if err != nil {
+			return viewerRequestFunction, err
+		}
+		viewerRequestFunction.AddStep(step)
+		fmt.Printf("Compiled %d redirect rules into the CloudFront Function\n", len(redirectRules))
+	}
+	if s3ObjectStorageProviderManager.options.StripHtmlExtensions {
+		viewerRequestFunction.AddStep(stripHtmlStep)
+	} else if s3ObjectStorageProviderManager.options.PrettyUrls {
+		filesToUpload, err := NewUploadFileFinder().FindFiles(s3ObjectStorageProviderManager.folderName, 0)
+		if err != nil {
+			return viewerRequestFunction, err
+		}
+		step, err := prettyUrlsStep(filesToUpload, s3ObjectStorageProviderManager.options.SiteMode == SiteModeSpa)
+		if err != nil {
+			return viewerRequestFunction, err
+		}
+		viewerRequestFunction.AddStep(step)
+	}
+	return viewerRequestFunction, nil
+}
+
+// prepareDistributionSettings publishes the function and policies the deploy options call for
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) prepareDistributionSettings(ctx context.Context) (distributionSettings, error) {
+	options := s3ObjectStorageProviderManager.options
+	cloudfrontClient := s3ObjectStorageProviderManager.cloudfrontClient
+	settings := distributionSettings{
+		originPath:            releaseOriginPath(s3ObjectStorageProviderManager.releaseId),
+		defaultCachePolicyId:  options.CachePolicyId,
+		originRequestPolicyId: options.OriginRequestPolicyId,
+		siteMode:              options.SiteMode,
+		priceClass:            options.PriceClass,
+		httpVersion:           options.HttpVersion,
+		ipv6Enabled:           options.Ipv6Enabled,
+		logBucket:             logBucketDomainName(s3ObjectStorageProviderManager.logBucket),
+		logPrefix:             s3ObjectStorageProviderManager.logPrefix,
+	}
+
+	viewerRequestFunction, err := s3ObjectStorageProviderManager.viewerRequestFunction()
+	if err != nil {
+		return settings, err
+	}
+	if !viewerRequestFunction.IsEmpty() {
+		functionArn, err := publishCloudFrontFunction(ctx, cloudfrontClient, cloudFrontResourceName("hostit", s3ObjectStorageProviderManager.domainName), "Hostit viewer request rewrites", viewerRequestFunction.Code())
+		if err != nil {
+			return settings, err
+		}
+		settings.viewerRequestFunctionArn = functionArn
+	}
+
+	headerRules, err := LoadHeaderRules(s3ObjectStorageProviderManager.folderName)
+	if err != nil {
+		return settings, err
+	}
+	// Rules for /* apply everywhere, so they join the headers of every behavior
+	var globalHeaders []HeaderField
+	for _, rule := range headerRules {
+		if rule.IsGlobal() {
+			globalHeaders = append(globalHeaders, rule.Headers...)
+		}
+	}
+	policyConfig, warnings := headerRulesPolicyConfig(options.SecurityHeaders, options.ContentSecurityPolicy, globalHeaders)
+	printHeaderRuleWarnings("/*", warnings)
+	if policyConfig != nil {
+		policyId, err := ensureResponseHeadersPolicy(ctx, cloudfrontClient, policyConfig)
+		if err != nil {
+			return settings, err
+		}
+		settings.responseHeadersPolicyId = policyId
+		fmt.Printf("Using security headers profile '%s' (response headers policy %s)\n", options.SecurityHeaders, policyId)
+	} else {
+		fmt.Printf("Using security headers profile '%s'\n", options.SecurityHeaders)
+	}
+
+	if settings.defaultCachePolicyId == "" {
+		policyId, err := ensureCachePolicyForTtls(ctx, cloudfrontClient, defaultCachePolicyMinTtl, defaultCachePolicyDefaultTtl, defaultCachePolicyMaxTtl)
+		if err != nil {
+			return settings, err
+		}
+		settings.defaultCachePolicyId = policyId
+	}
+	cacheControlPolicyIds := make([]string, 0, len(options.CacheControlRules))
+	for _, rule := range options.CacheControlRules {
+		minTtl, defaultTtl, maxTtl := rule.Ttls()
+		policyId, err := ensureCachePolicyForTtls(ctx, cloudfrontClient, minTtl, defaultTtl, maxTtl)
+		if err != nil {
+			return settings, err
+		}
+		cacheControlPolicyIds = append(cacheControlPolicyIds, policyId)
+	}
+
+	// Each _headers path gets a behavior with its own response headers policy. CloudFront picks
+	// a single behavior per request, so these come first and reuse the caching of the first
+	// Cache-Control rule matching the path.
+	for _, rule := range headerRules {
+		if rule.IsGlobal() {
+			continue
+		}
+		policyConfig, warnings := headerRulesPolicyConfig(options.SecurityHeaders, options.ContentSecurityPolicy, append(slices.Clone(globalHeaders), rule.Headers...))
+		printHeaderRuleWarnings(rule.Path, warnings)
+		if policyConfig == nil {
+			continue
+		}
+		policyId, err := ensureResponseHeadersPolicy(ctx, cloudfrontClient, policyConfig)
+		if err != nil {
+			return settings, err
+		}
+		for _, pathPattern := range rule.CloudFrontPathPatterns() {
+			behavior := pathCacheBehavior{
+				pathPattern:             pathPattern,
+				cachePolicyId:           settings.defaultCachePolicyId,
+				responseHeadersPolicyId: policyId,
+			}
+			for index, cacheControlRule := range options.CacheControlRules {
+				if matchPathGlob(cacheControlRule.Pattern, strings.TrimPrefix(rule.Path, "/")) {
+					behavior.cachePolicyId = cacheControlPolicyIds[index]
+					break
+				}
+			}
+			settings.pathCacheBehaviors = append(settings.pathCacheBehaviors, behavior)
+		}
+	}
+	// Mirror the Cache-Control rules as cache behaviors so CloudFront keeps objects for the same time
+	for index, rule := range options.CacheControlRules {
+		settings.pathCacheBehaviors = append(settings.pathCacheBehaviors, pathCacheBehavior{
+			pathPattern:   rule.CloudFrontPathPattern(),
+			cachePolicyId: cacheControlPolicyIds[index],
+		})
+	}
+	if len(settings.pathCacheBehaviors) > maxCacheBehaviors {
+		return settings, fmt.Errorf("_headers paths and Cache-Control rules need %d cache behaviors, more than the %d CloudFront allows per distribution by default; merge rules or request a quota increase", len(settings.pathCacheBehaviors), maxCacheBehaviors)
+	}
+	return settings, nil
+}
+
+func printHeaderRuleWarnings(path string, warnings []string) {
+	for _, warning := range warnings {
+		fmt.Printf("Warning: _headers rule for %s: %s\n", path, warning)
+	}
+}
+
+// updateDistribution applies settings to the distribution recorded in the deployment state
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) updateDistribution(ctx context.Context, settings distributionSettings) error {
+	distributionId := aws.String(s3ObjectStorageProviderManager.cloudfrontDistributionId)
+	getOut, err := s3ObjectStorageProviderManager.cloudfrontClient.GetDistributionConfig(ctx, &cloudfront.GetDistributionConfigInput{
+		Id: distributionId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed reading CloudFront distribution %s: %w", *distributionId, err)
+	}
+	distributionConfig := getOut.DistributionConfig
+	if distributionConfig == nil || distributionConfig.DefaultCacheBehavior == nil {
+		return errors.New("unexpected empty distribution config response")
+	}
+	// Keep routing to the origin the distribution was created with
+	originId := aws.ToString(distributionConfig.DefaultCacheBehavior.TargetOriginId)
+	previousPolicyIds := distributionResponseHeadersPolicyIds(distributionConfig)
+	applyDistributionSettings(distributionConfig, originId, settings)
+	for policyId := range distributionResponseHeadersPolicyIds(distributionConfig) {
+		previousPolicyIds.Remove(policyId)
+	}
+	_, err = s3ObjectStorageProviderManager.cloudfrontClient.UpdateDistribution(ctx, &cloudfront.UpdateDistributionInput{
+		Id:                 distributionId,
+		IfMatch:            getOut.ETag,
+		DistributionConfig: distributionConfig,
+	})
+	if err != nil {
+		return fmt.Errorf("failed updating CloudFront distribution %s: %w", *distributionId, err)
+	}
+	fmt.Printf("Updated CloudFront distribution %s\n", *distributionId)
+	// Policies of earlier _headers rules would otherwise pile up against the account quota
+	deleteUnusedHeaderRulesPolicies(ctx, s3ObjectStorageProviderManager.cloudfrontClient, previousPolicyIds)
 	return nil
 }
 
@@ -310,11 +1103,61 @@ func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) GetRequired
 	return records, nil
 }
 
-func NewS3ObjectStorageProviderManager(domainName string, folderName string) (*S3ObjectStorageProviderManager, error) {
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) bucketName() string {
+	return s3ObjectStorageProviderManager.bucket
+}
+
+// contentDigestMetadataKey is the user metadata (x-amz-meta-hostit-md5) holding the digest
+// s3ETagForFile computed when the object was uploaded
+const contentDigestMetadataKey = "hostit-md5"
+
+// s3ETagForFile predicts the ETag S3 assigns to the file when uploaded with partSize: the
+// content MD5 for single-part uploads, or the MD5 of the part MD5s plus "-<parts>" otherwise.
+func s3ETagForFile(fullPath string, partSize int64) (string, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open '%s': %w", fullPath, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Size() <= partSize {
+		hash := md5.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			return "", fmt.Errorf("failed to hash '%s': %w", fullPath, err)
+		}
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+	var partDigests []byte
+	parts := 0
+	for {
+		hash := md5.New()
+		n, err := io.CopyN(hash, f, partSize)
+		if n > 0 {
+			partDigests = hash.Sum(partDigests)
+			parts++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to hash '%s': %w", fullPath, err)
+		}
+	}
+	combined := md5.Sum(partDigests)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(combined[:]), parts), nil
+}
+
+func NewS3ObjectStorageProviderManager(domainName string, folderName string, options DeployOptions) (*S3ObjectStorageProviderManager, error) {
 	return &S3ObjectStorageProviderManager{
 		domainName:                       domainName,
 		folderName:                       folderName,
+		options:                          options,
 		awsAccountNumber:                 "",
+		bucket:                           "",
+		region:                           "",
 		s3Client:                         nil,
 		cloudfrontClient:                 nil,
 		acmClientUsEast1:                 nil,
@@ -322,5 +1165,14 @@ func NewS3ObjectStorageProviderManager(domainName string, folderName string) (*S
 		cloudfrontDistributionId:         "",
 		certificateArn:                   "",
 		acmValidationRecords:             nil,
+		deploymentState:                  nil,
+		adoptableState:                   nil,
+		changedPaths:                     nil,
+		releaseId:                        "",
+		keyPrefix:                        "",
+		copySourcePrefix:                 "",
+		kmsKeyChanged:                    false,
+		logBucket:                        "",
+		logPrefix:                        "",
 	}, nil
 }
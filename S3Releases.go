@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Atomic releases are uploaded under releases/<id>/ and served by pointing the CloudFront
+// origin path at them
+const releasesKeyPrefix = "releases/"
+
+// Releases made before ids carried nanoseconds have none
+var releaseIdPattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}(\.[0-9]{9})?Z$`)
+
+func releaseKeyPrefix(releaseId string) string {
+	return releasesKeyPrefix + releaseId + "/"
+}
+
+// releaseOriginPath is the origin path serving releaseId, or the bucket root when it is empty
+func releaseOriginPath(releaseId string) string {
+	if releaseId == "" {
+		return ""
+	}
+	return "/" + releasesKeyPrefix + releaseId
+}
+
+// enableVersioning keeps every object version so that a release can be restored after later
+// deploys overwrite it
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) enableVersioning(ctx context.Context, bucketName string) error {
+	_, err := s3ObjectStorageProviderManager.s3Client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
+		Bucket: aws.String(bucketName),
+		VersioningConfiguration: &s3Types.VersioningConfiguration{
+			Status: s3Types.BucketVersioningStatusEnabled,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable versioning on bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// currentObjectVersions maps each live key at the bucket root to its latest version. Objects
+// written before versioning was enabled have the version "null". Atomic release copies are left
+// out, since they are not part of the root's content.
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) currentObjectVersions(ctx context.Context, bucketName string) (map[string]string, error) {
+	versions := map[string]string{}
+	paginator := s3.NewListObjectVersionsPaginator(s3ObjectStorageProviderManager.s3Client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucketName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list object versions in bucket '%s': %w", bucketName, err)
+		}
+		// Deleted keys have a delete marker as their latest version and are left out
+		for _, version := range page.Versions {
+			if version.Key == nil || !aws.ToBool(version.IsLatest) || strings.HasPrefix(*version.Key, releasesKeyPrefix) {
+				continue
+			}
+			versions[*version.Key] = aws.ToString(version.VersionId)
+		}
+	}
+	return versions, nil
+}
+
+// recordRelease saves the live object versions as a new release
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) recordRelease(ctx context.Context, restoredFrom string) error {
+	manifest := &ReleaseManifest{
+		Id:           NewReleaseId(),
+		Domain:       s3ObjectStorageProviderManager.domainName,
+		Backend:      "s3",
+		CreatedAt:    time.Now().UTC(),
+		RestoredFrom: restoredFrom,
+	}
+	// An atomic release stays whole under its prefix, so the prefix is all there is to record
+	if s3ObjectStorageProviderManager.releaseId != "" {
+		manifest.Id = s3ObjectStorageProviderManager.releaseId
+		manifest.Prefix = s3ObjectStorageProviderManager.keyPrefix
+		if err := manifest.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Recorded release %s\n", manifest.Id)
+		return nil
+	}
+	versions, err := s3ObjectStorageProviderManager.currentObjectVersions(ctx, s3ObjectStorageProviderManager.bucketName())
+	if err != nil {
+		return err
+	}
+	manifest.Objects = versions
+	if err := manifest.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Recorded release %s (%d objects)\n", manifest.Id, len(versions))
+	return nil
+}
+
+// RestoreRelease makes the objects of manifest live again by copying each recorded version
+// over its key and deleting keys the release did not have. The restore is recorded as a new
+// release, and the touched paths are left for InvalidateCache.
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) RestoreRelease(manifest *ReleaseManifest) error {
+	ctx := context.Background()
+	bucketName := s3ObjectStorageProviderManager.bucketName()
+	if manifest.Prefix != "" {
+		return s3ObjectStorageProviderManager.activateRelease(ctx, manifest)
+	}
+	currentVersions, err := s3ObjectStorageProviderManager.currentObjectVersions(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+
+	var restoredKeys []string
+	for key, versionId := range manifest.Objects {
+		if currentVersions[key] == versionId {
+			continue
+		}
+		_, err := s3ObjectStorageProviderManager.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(bucketName),
+			Key:        aws.String(key),
+			CopySource: aws.String(bucketName + "/" + url.PathEscape(key) + "?versionId=" + url.QueryEscape(versionId)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to restore '%s' from release %s: %w", key, manifest.Id, err)
+		}
+		restoredKeys = append(restoredKeys, key)
+	}
+	var staleKeys []string
+	for key := range currentVersions {
+		if _, ok := manifest.Objects[key]; !ok {
+			staleKeys = append(staleKeys, key)
+		}
+	}
+	sort.Strings(restoredKeys)
+	sort.Strings(staleKeys)
+	if err := s3ObjectStorageProviderManager.deleteObjects(ctx, bucketName, staleKeys); err != nil {
+		return err
+	}
+	fmt.Printf("Restored release %s: %d files restored, %d removed\n", manifest.Id, len(restoredKeys), len(staleKeys))
+	s3ObjectStorageProviderManager.changedPaths = append(restoredKeys, staleKeys...)
+	// Serve the bucket root again if an atomic release was live
+	if s3ObjectStorageProviderManager.deploymentState.ReleaseId != "" {
+		if err := s3ObjectStorageProviderManager.switchOriginPath(ctx, ""); err != nil {
+			return err
+		}
+		s3ObjectStorageProviderManager.deploymentState.ReleaseId = ""
+		if err := s3ObjectStorageProviderManager.deploymentState.Save(); err != nil {
+			return err
+		}
+	}
+	return s3ObjectStorageProviderManager.recordRelease(ctx, manifest.Id)
+}
+
+// activateRelease points CloudFront back at an atomic release that is still in the bucket.
+// Every path of the live and the restored release is left for InvalidateCache.
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) activateRelease(ctx context.Context, manifest *ReleaseManifest) error {
+	bucketName := s3ObjectStorageProviderManager.bucketName()
+	targetETags, err := s3ObjectStorageProviderManager.listObjectETags(ctx, bucketName, manifest.Prefix)
+	if err != nil {
+		return err
+	}
+	if len(targetETags) == 0 {
+		return fmt.Errorf("release %s is no longer in bucket %s", manifest.Id, bucketName)
+	}
+	livePrefix := ""
+	if s3ObjectStorageProviderManager.deploymentState.ReleaseId != "" {
+		livePrefix = releaseKeyPrefix(s3ObjectStorageProviderManager.deploymentState.ReleaseId)
+	}
+	liveETags, err := s3ObjectStorageProviderManager.listObjectETags(ctx, bucketName, livePrefix)
+	if err != nil {
+		return err
+	}
+
+	if err := s3ObjectStorageProviderManager.switchOriginPath(ctx, releaseOriginPath(manifest.Id)); err != nil {
+		return err
+	}
+	s3ObjectStorageProviderManager.deploymentState.ReleaseId = manifest.Id
+	if err := s3ObjectStorageProviderManager.deploymentState.Save(); err != nil {
+		return err
+	}
+	changedPaths := NewSet[string]()
+	for key, etag := range targetETags {
+		if liveETags[key] != etag {
+			changedPaths.Add(key)
+		}
+	}
+	for key := range liveETags {
+		if _, ok := targetETags[key]; !ok {
+			changedPaths.Add(key)
+		}
+	}
+	s3ObjectStorageProviderManager.changedPaths = nil
+	for key := range changedPaths {
+		s3ObjectStorageProviderManager.changedPaths = append(s3ObjectStorageProviderManager.changedPaths, key)
+	}
+	sort.Strings(s3ObjectStorageProviderManager.changedPaths)
+	fmt.Printf("Switched CloudFront to release %s\n", manifest.Id)
+	return nil
+}
+
+// switchOriginPath points the distribution's S3 origin at originPath, leaving its other
+// settings as they are
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) switchOriginPath(ctx context.Context, originPath string) error {
+	if s3ObjectStorageProviderManager.deploymentState == nil || s3ObjectStorageProviderManager.deploymentState.DistributionId == "" {
+		return errors.New("no CloudFront distribution recorded for this site")
+	}
+	distributionId := aws.String(s3ObjectStorageProviderManager.deploymentState.DistributionId)
+	getOut, err := s3ObjectStorageProviderManager.cloudfrontClient.GetDistributionConfig(ctx, &cloudfront.GetDistributionConfigInput{
+		Id: distributionId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed reading CloudFront distribution %s: %w", *distributionId, err)
+	}
+	distributionConfig := getOut.DistributionConfig
+	if distributionConfig == nil || distributionConfig.DefaultCacheBehavior == nil || distributionConfig.Origins == nil {
+		return errors.New("unexpected empty distribution config response")
+	}
+	originId := aws.ToString(distributionConfig.DefaultCacheBehavior.TargetOriginId)
+	for index := range distributionConfig.Origins.Items {
+		if aws.ToString(distributionConfig.Origins.Items[index].Id) == originId {
+			distributionConfig.Origins.Items[index].OriginPath = aws.String(originPath)
+		}
+	}
+	_, err = s3ObjectStorageProviderManager.cloudfrontClient.UpdateDistribution(ctx, &cloudfront.UpdateDistributionInput{
+		Id:                 distributionId,
+		IfMatch:            getOut.ETag,
+		DistributionConfig: distributionConfig,
+	})
+	if err != nil {
+		return fmt.Errorf("failed updating CloudFront distribution %s: %w", *distributionId, err)
+	}
+	return nil
+}
+
+// copyFromPreviousRelease fills an unchanged file into the new release prefix with a
+// server-side copy from the live release. Outside atomic releases there is nothing to do.
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) copyFromPreviousRelease(ctx context.Context, bucketName string, repoPath string) error {
+	if s3ObjectStorageProviderManager.copySourcePrefix == "" {
+		return nil
+	}
+	_, err := s3ObjectStorageProviderManager.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(bucketName),
+		Key:        aws.String(s3ObjectStorageProviderManager.keyPrefix + repoPath),
+		CopySource: aws.String(bucketName + "/" + url.PathEscape(s3ObjectStorageProviderManager.copySourcePrefix+repoPath)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy unchanged '%s' into the new release: %w", repoPath, err)
+	}
+	return nil
+}
+
+// pruneReleases deletes atomic releases beyond KeepReleases, never the live one. A deploy without
+// atomic releases serves the bucket root, so once CloudFront has switched to it every release copy
+// is deleted.
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) pruneReleases(ctx context.Context) error {
+	bucketName := s3ObjectStorageProviderManager.bucketName()
+	releaseIds, err := s3ObjectStorageProviderManager.listReleaseIds(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+	keepReleases := s3ObjectStorageProviderManager.options.KeepReleases
+	if !s3ObjectStorageProviderManager.options.AtomicReleases {
+		keepReleases = 0
+	}
+	if len(releaseIds) > keepReleases {
+		for _, releaseId := range releaseIds[:len(releaseIds)-keepReleases] {
+			if releaseId == s3ObjectStorageProviderManager.deploymentState.ReleaseId {
+				continue
+			}
+			if err := s3ObjectStorageProviderManager.deleteRelease(ctx, bucketName, releaseId); err != nil {
+				return err
+			}
+		}
+	}
+	if !s3ObjectStorageProviderManager.options.AtomicReleases {
+		return s3ObjectStorageProviderManager.pruneReleaseManifests()
+	}
+	return nil
+}
+
+// listReleaseIds returns the ids of the atomic releases in the bucket, oldest first
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) listReleaseIds(ctx context.Context, bucketName string) ([]string, error) {
+	var releaseIds []string
+	paginator := s3.NewListObjectsV2Paginator(s3ObjectStorageProviderManager.s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucketName),
+		Prefix:    aws.String(releasesKeyPrefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list releases in bucket '%s': %w", bucketName, err)
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			releaseId := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(commonPrefix.Prefix), releasesKeyPrefix), "/")
+			if releaseIdPattern.MatchString(releaseId) {
+				releaseIds = append(releaseIds, releaseId)
+			}
+		}
+	}
+	sort.Strings(releaseIds)
+	return releaseIds, nil
+}
+
+// deleteRelease deletes every version of an atomic release and forgets it
+func (s3ObjectStorageProviderManager *S3ObjectStorageProviderManager) deleteRelease(ctx context.Context, bucketName string, releaseId string) error {
+	var objects []s3Types.ObjectIdentifier
+	versionPaginator := s3.NewListObjectVersionsPaginator(s3ObjectStorageProviderManager.s3Client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(releaseKeyPrefix(releaseId)),
+	})
+	for versionPaginator.HasMorePages() {
+		page, err := versionPaginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list versions of release %s: %w", releaseId, err)
+		}
+		for _, version := range page.Versions {
+			objects = append(objects, s3Types.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
+		}
+		for _, deleteMarker := range page.DeleteMarkers {
+			objects = append(objects, s3Types.ObjectIdentifier{Key: deleteMarker.Key, VersionId: deleteMarker.VersionId})
+		}
+	}
+	if err := s3ObjectStorageProviderManager.deleteObjectIdentifiers(ctx, bucketName, objects); err != nil {
+		return err
+	}
+	if err := DeleteReleaseManifest(s3ObjectStorageProviderManager.domainName, releaseId); err != nil {
+		return err
+	}
+	fmt.Printf("Pruned release %s\n", releaseId)
+	return nil
+}
+
+// pruneReleaseManifests forgets in-place releases beyond KeepReleases, whose object versions the
+// bucket's lifecycle rule expires
+func (s3ObjectStorageProviderManager S3ObjectStorageProviderManager) pruneReleaseManifests() error {
+	manifests, err := LoadReleaseManifests(s3ObjectStorageProviderManager.domainName)
+	if err != nil {
+		return err
+	}
+	manifests = slices.DeleteFunc(manifests, func(manifest *ReleaseManifest) bool {
+		return manifest.Prefix != ""
+	})
+	keepReleases := s3ObjectStorageProviderManager.options.KeepReleases
+	if keepReleases < 1 {
+		return fmt.Errorf("invalid number of releases to keep: %d", keepReleases)
+	}
+	if len(manifests) <= keepReleases {
+		return nil
+	}
+	for _, manifest := range manifests[:len(manifests)-keepReleases] {
+		if err := DeleteReleaseManifest(s3ObjectStorageProviderManager.domainName, manifest.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
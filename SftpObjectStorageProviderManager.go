@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SftpObjectStorageProviderManager uploads each deploy into its own release directory on a
+// self-hosted server and then atomically repoints a "current" symlink at it, so the web
+// server (configured to serve <path>/current) never sees a half-uploaded site.
+type SftpObjectStorageProviderManager struct {
+	domainName     string
+	folderName     string
+	host           string
+	port           string
+	username       string
+	remotePath     string
+	privateKeyPath string
+	knownHostsPath string
+	serverIps      []net.IP
+	options        DeployOptions
+	sshClient      *ssh.Client
+	sftpClient     *sftp.Client
+	releaseId      string
+}
+
+func (sftpObjectStorageProviderManager *SftpObjectStorageProviderManager) InstantiateClient() error {
+	host := os.Getenv("HOSTIT_SFTP_HOST")
+	if host == "" {
+		return errors.New("HOSTIT_SFTP_HOST not set")
+	}
+	if splitHost, splitPort, err := net.SplitHostPort(host); err == nil {
+		host = splitHost
+		sftpObjectStorageProviderManager.port = splitPort
+	}
+	sftpObjectStorageProviderManager.host = host
+	sftpObjectStorageProviderManager.remotePath = os.Getenv("HOSTIT_SFTP_PATH")
+	if sftpObjectStorageProviderManager.remotePath == "" {
+		return errors.New("HOSTIT_SFTP_PATH not set")
+	}
+	if username := os.Getenv("HOSTIT_SFTP_USER"); username != "" {
+		sftpObjectStorageProviderManager.username = username
+	} else if currentUser, err := user.Current(); err == nil {
+		sftpObjectStorageProviderManager.username = currentUser.Username
+	}
+	homeDir, _ := os.UserHomeDir()
+	sftpObjectStorageProviderManager.privateKeyPath = os.Getenv("HOSTIT_SFTP_KEY")
+	sftpObjectStorageProviderManager.knownHostsPath = os.Getenv("HOSTIT_SFTP_KNOWN_HOSTS")
+	if sftpObjectStorageProviderManager.knownHostsPath == "" {
+		sftpObjectStorageProviderManager.knownHostsPath = filepath.Join(homeDir, ".ssh", "known_hosts")
+	}
+
+	authMethods, err := sftpObjectStorageProviderManager.authMethods(homeDir)
+	if err != nil {
+		return err
+	}
+	hostKeyCallback, err := knownhosts.New(sftpObjectStorageProviderManager.knownHostsPath)
+	if err != nil {
+		return fmt.Errorf("failed to load known hosts from '%s': %w", sftpObjectStorageProviderManager.knownHostsPath, err)
+	}
+	sshClient, err := ssh.Dial("tcp", net.JoinHostPort(host, sftpObjectStorageProviderManager.port), &ssh.ClientConfig{
+		User:            sftpObjectStorageProviderManager.username,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to '%s': %w", host, err)
+	}
+	sftpObjectStorageProviderManager.sshClient = sshClient
+	sftpObjectStorageProviderManager.sftpClient, err = sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return fmt.Errorf("failed to start sftp session: %w", err)
+	}
+
+	// The DNS records point at the server itself, so an explicit IP wins over resolving the host
+	if serverIp := os.Getenv("HOSTIT_SFTP_SERVER_IP"); serverIp != "" {
+		for _, value := range strings.Split(serverIp, ",") {
+			ip := net.ParseIP(strings.TrimSpace(value))
+			if ip == nil {
+				return fmt.Errorf("invalid server ip '%s'", value)
+			}
+			sftpObjectStorageProviderManager.serverIps = append(sftpObjectStorageProviderManager.serverIps, ip)
+		}
+	} else {
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			return fmt.Errorf("failed to resolve server ip for '%s': %w", host, err)
+		}
+		sftpObjectStorageProviderManager.serverIps = ips
+	}
+	fmt.Printf("Using %s@%s:%s for object storage provider\n", sftpObjectStorageProviderManager.username, host, sftpObjectStorageProviderManager.remotePath)
+	return nil
+}
+
+func (sftpObjectStorageProviderManager *SftpObjectStorageProviderManager) VerifyNamespace() (bool, error) {
+	if sftpObjectStorageProviderManager.sftpClient == nil {
+		return false, errors.New("sftp client not instantiated")
+	}
+	// Releases are side by side, so an existing site is simply replaced by the next release
+	currentPath := path.Join(sftpObjectStorageProviderManager.remotePath, "current")
+	if target, err := sftpObjectStorageProviderManager.sftpClient.ReadLink(currentPath); err == nil {
+		fmt.Printf("Existing release '%s' will be replaced\n", target)
+	}
+	return true, nil
+}
+
+func (sftpObjectStorageProviderManager *SftpObjectStorageProviderManager) CreateStorageInstance() error {
+	if sftpObjectStorageProviderManager.sftpClient == nil {
+		return errors.New("sftp client not instantiated")
+	}
+	releasesPath := path.Join(sftpObjectStorageProviderManager.remotePath, "releases")
+	if err := sftpObjectStorageProviderManager.sftpClient.MkdirAll(releasesPath); err != nil {
+		return fmt.Errorf("failed to create releases directory '%s': %w", releasesPath, err)
+	}
+	return nil
+}
+
+func (sftpObjectStorageProviderManager *SftpObjectStorageProviderManager) UploadFilesToNewInstance() error {
+	const maxFileSizeBytes int64 = 1 * 1024 * 1024 * 1024 // 1GB
+
+	client := sftpObjectStorageProviderManager.sftpClient
+	if client == nil {
+		return errors.New("sftp client not instantiated")
+	}
+
+	finder := NewUploadFileFinder()
+	filesToUpload, err := finder.FindFiles(sftpObjectStorageProviderManager.folderName, maxFileSizeBytes)
+	if err != nil {
+		return err
+	}
+
+	releaseId := NewReleaseId()
+	releasePath := path.Join(sftpObjectStorageProviderManager.remotePath, "releases", releaseId)
+	if err := client.MkdirAll(releasePath); err != nil {
+		return fmt.Errorf("failed to create release directory '%s': %w", releasePath, err)
+	}
+
+	createdDirs := NewSet[string]()
+	createdDirs.Add(releasePath)
+	for _, repoPath := range filesToUpload {
+		fullPath := filepath.Join(sftpObjectStorageProviderManager.folderName, filepath.FromSlash(repoPath))
+		remoteFilePath := path.Join(releasePath, repoPath)
+		remoteDir := path.Dir(remoteFilePath)
+		if !createdDirs.Contains(remoteDir) {
+			if err := client.MkdirAll(remoteDir); err != nil {
+				return fmt.Errorf("failed to create directory '%s': %w", remoteDir, err)
+			}
+			createdDirs.Add(remoteDir)
+		}
+		if err := sftpObjectStorageProviderManager.uploadFile(fullPath, remoteFilePath); err != nil {
+			return fmt.Errorf("failed to upload '%s': %w", repoPath, err)
+		}
+		log.Printf("Uploaded '%s'", repoPath)
+	}
+	sftpObjectStorageProviderManager.releaseId = releaseId
+	return nil
+}
+
+func (sftpObjectStorageProviderManager *SftpObjectStorageProviderManager) CreateAvailableDomain() error {
+	client := sftpObjectStorageProviderManager.sftpClient
+	if client == nil {
+		return errors.New("sftp client not instantiated")
+	}
+	if sftpObjectStorageProviderManager.releaseId == "" {
+		return errors.New("no release uploaded")
+	}
+	// Nothing else needs the server once the release is live
+	defer sftpObjectStorageProviderManager.close()
+	// Build the new link beside "current" and rename it over the old one so the swap is atomic
+	currentPath := path.Join(sftpObjectStorageProviderManager.remotePath, "current")
+	temporaryPath := currentPath + ".hostit-" + sftpObjectStorageProviderManager.releaseId
+	if err := client.Symlink(path.Join("releases", sftpObjectStorageProviderManager.releaseId), temporaryPath); err != nil {
+		return fmt.Errorf("failed to create release symlink: %w", err)
+	}
+	if err := client.PosixRename(temporaryPath, currentPath); err != nil {
+		_ = client.Remove(temporaryPath)
+		return fmt.Errorf("failed to switch current release (server must support posix-rename): %w", err)
+	}
+	fmt.Printf("Release %s is now live\n", sftpObjectStorageProviderManager.releaseId)
+	return sftpObjectStorageProviderManager.pruneReleases()
+}
+
+// pruneReleases deletes release directories beyond KeepReleases, oldest first, never the live one
+func (sftpObjectStorageProviderManager *SftpObjectStorageProviderManager) pruneReleases() error {
+	keepReleases := sftpObjectStorageProviderManager.options.KeepReleases
+	if keepReleases < 1 {
+		return fmt.Errorf("invalid number of releases to keep: %d", keepReleases)
+	}
+	client := sftpObjectStorageProviderManager.sftpClient
+	releasesPath := path.Join(sftpObjectStorageProviderManager.remotePath, "releases")
+	entries, err := client.ReadDir(releasesPath)
+	if err != nil {
+		return fmt.Errorf("failed to list releases in '%s': %w", releasesPath, err)
+	}
+	var releaseIds []string
+	for _, entry := range entries {
+		if entry.IsDir() && releaseIdPattern.MatchString(entry.Name()) {
+			releaseIds = append(releaseIds, entry.Name())
+		}
+	}
+	sort.Strings(releaseIds)
+	if len(releaseIds) <= keepReleases {
+		return nil
+	}
+	for _, releaseId := range releaseIds[:len(releaseIds)-keepReleases] {
+		if releaseId == sftpObjectStorageProviderManager.releaseId {
+			continue
+		}
+		if err := client.RemoveAll(path.Join(releasesPath, releaseId)); err != nil {
+			return fmt.Errorf("failed to delete release %s: %w", releaseId, err)
+		}
+		fmt.Printf("Pruned release %s\n", releaseId)
+	}
+	return nil
+}
+
+// close ends the sftp session and the ssh connection under it
+func (sftpObjectStorageProviderManager *SftpObjectStorageProviderManager) close() {
+	if sftpObjectStorageProviderManager.sftpClient != nil {
+		sftpObjectStorageProviderManager.sftpClient.Close()
+		sftpObjectStorageProviderManager.sftpClient = nil
+	}
+	if sftpObjectStorageProviderManager.sshClient != nil {
+		sftpObjectStorageProviderManager.sshClient.Close()
+		sftpObjectStorageProviderManager.sshClient = nil
+	}
+}
+
+func (sftpObjectStorageProviderManager *SftpObjectStorageProviderManager) GetRequiredDnsRecords() ([]*types.ResourceRecordSet, error) {
+	var ipv4Records, ipv6Records []types.ResourceRecord
+	for _, ip := range sftpObjectStorageProviderManager.serverIps {
+		if ip.To4() != nil {
+			ipv4Records = append(ipv4Records, types.ResourceRecord{Value: aws.String(ip.String())})
+		} else {
+			ipv6Records = append(ipv6Records, types.ResourceRecord{Value: aws.String(ip.String())})
+		}
+	}
+	var records []*types.ResourceRecordSet
+	if len(ipv4Records) > 0 {
+		records = append(records, &types.ResourceRecordSet{
+			Name:            aws.String(sftpObjectStorageProviderManager.domainName + "."),
+			Type:            types.RRTypeA,
+			TTL:             aws.Int64(300),
+			ResourceRecords: ipv4Records,
+		})
+	}
+	if len(ipv6Records) > 0 {
+		records = append(records, &types.ResourceRecordSet{
+			Name:            aws.String(sftpObjectStorageProviderManager.domainName + "."),
+			Type:            types.RRTypeAaaa,
+			TTL:             aws.Int64(300),
+			ResourceRecords: ipv6Records,
+		})
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no server ip known for dns records")
+	}
+	return records, nil
+}
+
+func (sftpObjectStorageProviderManager *SftpObjectStorageProviderManager) FinalizeHttps() error {
+	return nil
+}
+
+func (sftpObjectStorageProviderManager *SftpObjectStorageProviderManager) uploadFile(fullPath string, remoteFilePath string) error {
+	localFile, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer localFile.Close()
+	remoteFile, err := sftpObjectStorageProviderManager.sftpClient.Create(remoteFilePath)
+	if err != nil {
+		return err
+	}
+	_, copyErr := remoteFile.ReadFrom(localFile)
+	closeErr := remoteFile.Close()
+	if copyErr != nil {
+		return copyErr
+	}
+	return closeErr
+}
+
+// authMethods prefers an explicitly configured key, then the running ssh-agent, then the
+// default keys in ~/.ssh.
+func (sftpObjectStorageProviderManager *SftpObjectStorageProviderManager) authMethods(homeDir string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if sftpObjectStorageProviderManager.privateKeyPath != "" {
+		signer, err := loadSshSigner(sftpObjectStorageProviderManager.privateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	for _, keyName := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		signer, err := loadSshSigner(filepath.Join(homeDir, ".ssh", keyName))
+		if err == nil {
+			methods = append(methods, ssh.PublicKeys(signer))
+		}
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no ssh credentials found; set HOSTIT_SFTP_KEY or run an ssh-agent")
+	}
+	return methods, nil
+}
+
+func loadSshSigner(keyPath string) (ssh.Signer, error) {
+	keyBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ssh key '%s': %w", keyPath, err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh key '%s': %w", keyPath, err)
+	}
+	return signer, nil
+}
+
+func NewSftpObjectStorageProviderManager(domainName string, folderName string, options DeployOptions) (*SftpObjectStorageProviderManager, error) {
+	if folderName == "" {
+		return nil, errors.New("folderName must not be empty")
+	}
+	return &SftpObjectStorageProviderManager{
+		domainName:     domainName,
+		folderName:     folderName,
+		host:           "",
+		port:           "22",
+		username:       "",
+		remotePath:     "",
+		privateKeyPath: "",
+		knownHostsPath: "",
+		serverIps:      nil,
+		options:        options,
+		sshClient:      nil,
+		sftpClient:     nil,
+		releaseId:      "",
+	}, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startTestSftpServer serves the local file system over SFTP on a random port to the holder of
+// the returned client key, and writes a known_hosts file trusting the server
+func startTestSftpServer(t *testing.T) (address string, keyPath string, knownHostsPath string) {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPublicKey, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizedKey, err := ssh.NewPublicKey(clientPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() != "deploy" || string(key.Marshal()) != string(authorizedKey.Marshal()) {
+				return nil, os.ErrPermission
+			}
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSftpConnection(conn, serverConfig)
+		}
+	}()
+
+	dir := t.TempDir()
+	keyBlock, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath = filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(keyBlock), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	address = listener.Addr().String()
+	knownHostsPath = filepath.Join(dir, "known_hosts")
+	knownHostsLine := knownhosts.Line([]string{knownhosts.Normalize(address)}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHostsPath, []byte(knownHostsLine+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return address, keyPath, knownHostsPath
+}
+
+func serveTestSftpConnection(conn net.Conn, serverConfig *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are served")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for request := range channelRequests {
+				isSftp := request.Type == "subsystem" && len(request.Payload) > 4 && string(request.Payload[4:]) == "sftp"
+				request.Reply(isSftp, nil)
+				if !isSftp {
+					continue
+				}
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					channel.Close()
+					return
+				}
+				server.Serve()
+				channel.Close()
+			}
+		}()
+	}
+}
+
+func deployToTestSftpServer(t *testing.T, folderName string, options DeployOptions) *SftpObjectStorageProviderManager {
+	t.Helper()
+	manager, err := NewSftpObjectStorageProviderManager("www.example.com", folderName, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []func() error{
+		manager.InstantiateClient,
+		func() error {
+			available, err := manager.VerifyNamespace()
+			if err == nil && !available {
+				t.Error("VerifyNamespace() = false; an existing site should be replaced")
+			}
+			return err
+		},
+		manager.CreateStorageInstance,
+		manager.UploadFilesToNewInstance,
+		manager.CreateAvailableDomain,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return manager
+}
+
+func TestSftpDeploy(t *testing.T) {
+	address, keyPath, knownHostsPath := startTestSftpServer(t)
+	remotePath := t.TempDir()
+	t.Setenv("HOSTIT_SFTP_HOST", address)
+	t.Setenv("HOSTIT_SFTP_PATH", remotePath)
+	t.Setenv("HOSTIT_SFTP_USER", "deploy")
+	t.Setenv("HOSTIT_SFTP_KEY", keyPath)
+	t.Setenv("HOSTIT_SFTP_KNOWN_HOSTS", knownHostsPath)
+	t.Setenv("HOSTIT_SFTP_SERVER_IP", "203.0.113.10, 2001:db8::10")
+
+	options := DeployOptions{KeepReleases: 2}
+	var releaseIds []string
+	for _, content := range []string{"first", "second", "third"} {
+		folderName := writeTestSite(t, map[string]string{
+			"index.html":   content,
+			"css/site.css": "body { margin: 0 }",
+		})
+		manager := deployToTestSftpServer(t, folderName, options)
+		if manager.sftpClient != nil || manager.sshClient != nil {
+			t.Error("connection still open after the release went live")
+		}
+		releaseIds = append(releaseIds, manager.releaseId)
+
+		served, err := os.ReadFile(filepath.Join(remotePath, "current", "index.html"))
+		if err != nil || string(served) != content {
+			t.Fatalf("current/index.html = %q, %v; want %q", served, err, content)
+		}
+		if _, err := os.Stat(filepath.Join(remotePath, "current", "css", "site.css")); err != nil {
+			t.Error(err)
+		}
+	}
+
+	target, err := os.Readlink(filepath.Join(remotePath, "current"))
+	if err != nil || target != filepath.Join("releases", releaseIds[2]) {
+		t.Errorf("current links to %s, %v; want the last release", target, err)
+	}
+	entries, err := os.ReadDir(filepath.Join(remotePath, "releases"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, entry := range entries {
+		kept = append(kept, entry.Name())
+	}
+	if strings.Join(kept, " ") != releaseIds[1]+" "+releaseIds[2] {
+		t.Errorf("releases = %v; want the last two of %v", kept, releaseIds)
+	}
+	// The temporary link used for the swap must not be left behind
+	if matches, _ := filepath.Glob(filepath.Join(remotePath, "current.hostit-*")); len(matches) > 0 {
+		t.Errorf("left %v", matches)
+	}
+}
+
+func TestSftpDnsRecords(t *testing.T) {
+	manager, err := NewSftpObjectStorageProviderManager("www.example.com", "site", DeployOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.serverIps = []net.IP{net.ParseIP("203.0.113.10"), net.ParseIP("2001:db8::10")}
+	records, err := manager.GetRequiredDnsRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Type != "A" || records[1].Type != "AAAA" {
+		t.Fatalf("records = %v; want one A and one AAAA record", records)
+	}
+	if value := *records[1].ResourceRecords[0].Value; value != "2001:db8::10" {
+		t.Errorf("AAAA record = %s", value)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// accessLogHourPattern finds the hour in CloudFront log file names,
+// "<prefix><distribution id>.YYYY-MM-DD-HH.<unique id>.gz"
+var accessLogHourPattern = regexp.MustCompile(`\.(\d{4}-\d{2}-\d{2}-\d{2})\.[^.]+\.gz$`)
+
+// RunStats implements "hostit stats [options] <domain_name>", which summarizes the CloudFront
+// access logs of an S3 site
+func RunStats(args []string) error {
+	flagSet := flag.NewFlagSet("hostit stats", flag.ContinueOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), "Usage: hostit stats [options] <domain_name>")
+		flagSet.PrintDefaults()
+	}
+	since := flagSet.Duration("since", 24*time.Hour, "report on this much time before now")
+	from := flagSet.String("from", "", "start of the report as YYYY-MM-DD or RFC 3339, instead of -since")
+	until := flagSet.String("until", "", "end of the report, exclusive, as YYYY-MM-DD or RFC 3339 (default: now)")
+	top := flagSet.Int("top", 10, "number of paths and referrers listed")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+		return errors.New("expected a domain name")
+	}
+	if *top < 1 {
+		return errors.New("-top must be at least 1")
+	}
+	domainName := flagSet.Arg(0)
+
+	reportUntil := time.Now()
+	if *until != "" {
+		var err error
+		if reportUntil, err = parseReportTime(*until); err != nil {
+			return err
+		}
+	}
+	reportFrom := reportUntil.Add(-*since)
+	if *from != "" {
+		var err error
+		if reportFrom, err = parseReportTime(*from); err != nil {
+			return err
+		}
+	}
+	if !reportFrom.Before(reportUntil) {
+		return errors.New("the start of the report must be before its end")
+	}
+
+	deploymentState, err := LoadDeploymentState(domainName)
+	if err != nil {
+		return err
+	}
+	if deploymentState == nil {
+		return fmt.Errorf("no deployment of %s is recorded on this machine", domainName)
+	}
+	if deploymentState.Backend != "s3" {
+		return fmt.Errorf("stats are read from CloudFront access logs, which %s deployments do not have", deploymentState.Backend)
+	}
+	if deploymentState.LogBucket == "" {
+		return fmt.Errorf("access logs are not enabled for %s; deploy it with -access-logs", domainName)
+	}
+
+	logFiles, err := downloadAccessLogs(context.Background(), deploymentState, reportFrom, reportUntil)
+	if err != nil {
+		return err
+	}
+	summary := NewAccessLogSummary(domainName, reportFrom, reportUntil)
+	for _, logFile := range logFiles {
+		entries, err := readAccessLogFile(logFile)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			summary.Add(entry)
+		}
+	}
+	summary.WriteReport(os.Stdout, *top)
+	return nil
+}
+
+func parseReportTime(value string) (time.Time, error) {
+	if date, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return date, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time '%s' (expected YYYY-MM-DD or RFC 3339)", value)
+	}
+	return timestamp, nil
+}
+
+func readAccessLogFile(logFile string) ([]AccessLogEntry, error) {
+	file, err := os.Open(logFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+	defer file.Close()
+	entries, err := ParseAccessLog(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(logFile), err)
+	}
+	return entries, nil
+}
+
+// downloadAccessLogs copies the distribution's log files covering from to until into a local
+// cache next to the deployment state, skipping files downloaded by an earlier run
+func downloadAccessLogs(ctx context.Context, deploymentState *DeploymentState, from time.Time, until time.Time) ([]string, error) {
+	stateDir, err := deploymentStateDir()
+	if err != nil {
+		return nil, err
+	}
+	cacheDir := filepath.Join(stateDir, deploymentState.Domain+".logs")
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log cache directory: %w", err)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("issue with getting credentials: %w", err)
+	}
+	region := deploymentState.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Region = region
+	})
+	// The log bucket can be in another region than the site bucket
+	logBucketRegion, err := manager.GetBucketRegion(ctx, s3Client, deploymentState.LogBucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed looking up log bucket %s: %w", deploymentState.LogBucket, err)
+	}
+	if logBucketRegion != region {
+		s3Client = s3.New(s3Client.Options(), func(o *s3.Options) {
+			o.Region = logBucketRegion
+		})
+	}
+
+	// Log files are named after the hour they cover, but late requests can land in the next file
+	firstHour := from.UTC().Truncate(time.Hour).Add(-time.Hour)
+	lastHour := until.UTC().Add(time.Hour)
+	keyPrefix := deploymentState.LogPrefix + deploymentState.DistributionId + "."
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket:     aws.String(deploymentState.LogBucket),
+		Prefix:     aws.String(keyPrefix),
+		StartAfter: aws.String(keyPrefix + firstHour.Format("2006-01-02-15")),
+	})
+	var logFiles []string
+	downloaded := 0
+	// Keys sort by hour, so listing stops at the first file after the report
+	pastReport := false
+	for paginator.HasMorePages() && !pastReport {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list access logs in bucket '%s': %w", deploymentState.LogBucket, err)
+		}
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			match := accessLogHourPattern.FindStringSubmatch(key)
+			if match == nil {
+				continue
+			}
+			hour, err := time.Parse("2006-01-02-15", match[1])
+			if err == nil && hour.After(lastHour) {
+				pastReport = true
+				break
+			}
+			if err != nil || hour.Before(firstHour) {
+				continue
+			}
+			localPath := filepath.Join(cacheDir, path.Base(key))
+			if info, err := os.Stat(localPath); err != nil || info.Size() != aws.ToInt64(object.Size) {
+				if err := downloadAccessLog(ctx, s3Client, deploymentState.LogBucket, key, localPath); err != nil {
+					return nil, err
+				}
+				downloaded++
+			}
+			logFiles = append(logFiles, localPath)
+		}
+	}
+	fmt.Printf("Read %d access log files (%d downloaded) from s3://%s/%s\n", len(logFiles), downloaded, deploymentState.LogBucket, deploymentState.LogPrefix)
+	return logFiles, nil
+}
+
+func downloadAccessLog(ctx context.Context, s3Client *s3.Client, bucketName string, key string, localPath string) error {
+	getOut, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download access log '%s': %w", key, err)
+	}
+	defer getOut.Body.Close()
+	// Write under a temporary name so an interrupted download is not mistaken for a cached file
+	temporaryPath := localPath + ".part"
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		return fmt.Errorf("failed to create '%s': %w", temporaryPath, err)
+	}
+	_, err = io.Copy(file, getOut.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporaryPath)
+		return fmt.Errorf("failed to download access log '%s': %w", key, err)
+	}
+	return os.Rename(temporaryPath, localPath)
+}
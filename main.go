@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -11,11 +13,34 @@ import (
 )
 
 func main() {
-	if len(os.Args) != 3 {
-		log.Fatalf("Usage: hostit <domain_name> <folder_name>")
+	subcommands := map[string]func([]string) error{
+		"releases": RunReleases,
+		"rollback": RunRollback,
+		"stats":    RunStats,
+	}
+	if len(os.Args) > 1 && subcommands[os.Args[1]] != nil {
+		err := subcommands[os.Args[1]](os.Args[2:])
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		if err != nil {
+			log.Fatalf("Error: %s", err.Error())
+		}
+		return
+	}
+
+	deployOptions, args, err := ParseDeployOptions(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Error: %s", err.Error())
+	}
+	if len(args) != 2 {
+		log.Fatalf("Usage: hostit [options] <domain_name> <folder_name>")
 	}
 
-	fullDomainName, folderName := os.Args[1], os.Args[2]
+	fullDomainName, folderName := args[0], args[1]
 	temporaryDomainName := fullDomainName
 	var domainName string
 	if strings.LastIndex(fullDomainName, ".") == strings.Index(fullDomainName, ".") || strings.LastIndex(fullDomainName, ".") == -1 {
@@ -38,7 +63,7 @@ func main() {
 			fmt.Printf("[%d]\t%s\n", index+1, value)
 		}
 		var numChosen int
-		_, err := fmt.Scanln(&numChosen)
+		_, err = fmt.Scanln(&numChosen)
 		if err != nil || numChosen > len(possibleDomainNames) {
 			log.Fatalf("invalid option chosen")
 		}
@@ -48,6 +73,7 @@ func main() {
 
 	dnsOptions := map[string]string{
 		"A": "AWS",
+		"L": "Local DNS records file (JSON)",
 	}
 	fmt.Println("What DNS Provider do you want to use?")
 	var dnsKeys []string
@@ -65,7 +91,13 @@ func main() {
 	}
 
 	objectStorageOptions := map[string]string{
+		"F": "Gitea/Forgejo/Codeberg",
 		"G": "Github",
+		"H": "Self-hosted (SFTP)",
+		"I": "IPFS",
+		"L": "GitLab",
+		"D": "Local directory",
+		"N": "Netlify",
 		"S": "S3",
 	}
 	fmt.Println("What object storage platform do you want to use?")
@@ -83,15 +115,38 @@ func main() {
 		log.Fatalf("Object storage provider not supported")
 	}
 	var objectStorageProviderManager ObjectStorageProviderManager
-	var err error
-	if enteredObjectStorageProvider == "G" {
+	switch enteredObjectStorageProvider {
+	case "D":
+		objectStorageProviderManager, err = NewLocalObjectStorageProviderManager(fullDomainName, folderName, deployOptions)
+	case "F":
+		objectStorageProviderManager, err = NewGiteaObjectStorageProviderManager(fullDomainName, folderName)
+	case "G":
 		objectStorageProviderManager, err = NewGithubObjectStorageProviderManager(fullDomainName, folderName)
-	} else {
-		objectStorageProviderManager, err = NewS3ObjectStorageProviderManager(fullDomainName, folderName)
+	case "H":
+		objectStorageProviderManager, err = NewSftpObjectStorageProviderManager(fullDomainName, folderName, deployOptions)
+	case "I":
+		objectStorageProviderManager, err = NewIpfsObjectStorageProviderManager(fullDomainName, folderName)
+	case "L":
+		objectStorageProviderManager, err = NewGitLabObjectStorageProviderManager(fullDomainName, folderName)
+	case "N":
+		objectStorageProviderManager, err = NewNetlifyObjectStorageProviderManager(fullDomainName, folderName)
+	default:
+		objectStorageProviderManager, err = NewS3ObjectStorageProviderManager(fullDomainName, folderName, deployOptions)
 	}
 	if err != nil {
 		log.Fatalf("Error: %s", err.Error())
 	}
+	// Basic auth is checked by the CloudFront Function, which no other backend has
+	if len(deployOptions.BasicAuthCredentials) > 0 && enteredObjectStorageProvider != "S" {
+		log.Fatalf("Error: %s sites cannot be password protected; basic auth needs the S3 backend", objectStorageOptions[enteredObjectStorageProvider])
+	}
+	// Only S3 (through CloudFront) and Netlify can send the headers from a _headers file
+	if enteredObjectStorageProvider != "S" && enteredObjectStorageProvider != "N" {
+		err = WarnUnsupportedHeaderRules(folderName, objectStorageOptions[enteredObjectStorageProvider])
+		if err != nil {
+			log.Fatalf("Error: %s", err.Error())
+		}
+	}
 	err = objectStorageProviderManager.InstantiateClient()
 	if err != nil {
 		log.Fatalf("Error: %s", err.Error())
@@ -101,7 +156,20 @@ func main() {
 		log.Fatalf("Error: %s", err.Error())
 	}
 	if !namespaceGood {
-		log.Fatalf("Repository with name %s already exists", fullDomainName)
+		deploymentAdopter, ok := objectStorageProviderManager.(DeploymentAdopter)
+		if !ok || deploymentAdopter.ExistingDeployment() == "" {
+			log.Fatalf("Repository with name %s already exists", fullDomainName)
+		}
+		fmt.Printf("%s\nAdopt it and update it in place? [y/N] ", deploymentAdopter.ExistingDeployment())
+		var adopt string
+		fmt.Scanln(&adopt)
+		if !strings.EqualFold(adopt, "y") {
+			log.Fatalf("Repository with name %s already exists", fullDomainName)
+		}
+		err = deploymentAdopter.AdoptExistingDeployment()
+		if err != nil {
+			log.Fatalf("Error: %s", err.Error())
+		}
 	}
 	err = objectStorageProviderManager.CreateStorageInstance()
 	if err != nil {
@@ -116,6 +184,12 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error: %s", err.Error())
 	}
+	if cacheInvalidator, ok := objectStorageProviderManager.(CacheInvalidator); ok {
+		err = cacheInvalidator.InvalidateCache()
+		if err != nil {
+			log.Fatalf("Error: %s", err.Error())
+		}
+	}
 	var resourceRecordSets []*types.ResourceRecordSet
 	resourceRecordSets, err = objectStorageProviderManager.GetRequiredDnsRecords()
 	if err != nil {
@@ -124,9 +198,11 @@ func main() {
 	var dnsProviderManager DnsProviderManager
 	if enteredDnsProvider == "A" {
 		dnsProviderManager, err = NewAwsDnsProviderManager(fullDomainName, domainName, resourceRecordSets)
-		if err != nil {
-			log.Fatalf("Error: %s", err.Error())
-		}
+	} else {
+		dnsProviderManager, err = NewLocalDnsProviderManager(fullDomainName, domainName, resourceRecordSets)
+	}
+	if err != nil {
+		log.Fatalf("Error: %s", err.Error())
 	}
 	err = dnsProviderManager.InstantiateClient()
 	if err != nil {
@@ -145,5 +221,11 @@ func main() {
 		log.Fatalf("Error: %s", err.Error())
 	}
 	fmt.Println("Subdomain records added")
+	if supersededContentPruner, ok := objectStorageProviderManager.(SupersededContentPruner); ok {
+		err = supersededContentPruner.PruneSupersededContent()
+		if err != nil {
+			log.Fatalf("Error: %s", err.Error())
+		}
+	}
 	fmt.Printf("Website should now be accessible at https://%s\n", fullDomainName)
 }